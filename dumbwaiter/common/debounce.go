@@ -0,0 +1,202 @@
+package common
+
+/*
+debounce.go implements a RPi decorator that filters contact bounce and electrical noise
+out of the button and sensor inputs before they reach the floor logic
+*/
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDebounceConfig suits the floor's push buttons and the at floor reed switch
+var DefaultDebounceConfig = DebounceConfig{SettleTime: 20 * time.Millisecond, MinPulseWidth: 50 * time.Millisecond}
+
+// DebounceConfig how long a pin's signal must hold before it is believed
+type DebounceConfig struct {
+	SettleTime    time.Duration // a new on or off signal must hold this long before it is reported
+	MinPulseWidth time.Duration // an on signal must hold this long before it is reported
+}
+
+// holdTime how long a change to value must hold before it is reported
+func (c DebounceConfig) holdTime(value bool) time.Duration {
+	if value && c.MinPulseWidth > c.SettleTime {
+		return c.MinPulseWidth
+	}
+	return c.SettleTime
+}
+
+// DebouncedRPi wraps a RPi, only reporting input signal changes that hold for the pin's
+// settle time (and minimum pulse width when turning on).  Changes that revert sooner are
+// counted as glitches and dropped.  Signals sent to the RPi are passed straight through.
+type DebouncedRPi struct {
+	device     RPi
+	clock      Clock
+	config     DebounceConfig
+	pinConfigs map[PiPin]DebounceConfig
+	polled     map[PiPin]*debounceState
+	glitches   map[PiPin]int
+	mu         sync.Mutex
+}
+
+// debounceState the filter state of a polled pin
+type debounceState struct {
+	stable       bool      // the reported signal
+	pending      bool      // the raw signal differs from the reported signal
+	pendingSince time.Time // when the raw signal was first seen to differ
+}
+
+// NewDebouncedRPi wrap device, debouncing all of its inputs with config
+func NewDebouncedRPi(device RPi, config DebounceConfig) *DebouncedRPi {
+	return &DebouncedRPi{
+		device:     device,
+		clock:      RealClock,
+		config:     config,
+		pinConfigs: map[PiPin]DebounceConfig{},
+		polled:     map[PiPin]*debounceState{},
+		glitches:   map[PiPin]int{},
+	}
+}
+
+// SendSignal pass the signal through to the wrapped device
+func (d *DebouncedRPi) SendSignal(pin PiPin) error {
+	return d.device.SendSignal(pin)
+}
+
+// GetSignal get the debounced signal, each call samples the wrapped device
+func (d *DebouncedRPi) GetSignal(pin PiPin) (bool, error) {
+	raw, err := d.device.GetSignal(pin)
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state, ok := d.polled[pin]
+	if !ok {
+		state = &debounceState{}
+		d.polled[pin] = state
+	}
+	now := d.clock.Now()
+
+	if raw == state.stable {
+		if state.pending {
+			d.glitches[pin]++
+			log.Debugf("rejected %s glitch after %s", pin, now.Sub(state.pendingSince))
+			state.pending = false
+		}
+		return state.stable, nil
+	}
+	if !state.pending {
+		state.pending = true
+		state.pendingSince = now
+	}
+	if now.Sub(state.pendingSince) >= d.pinConfigLocked(pin).holdTime(raw) {
+		state.stable = raw
+		state.pending = false
+	}
+	return state.stable, nil
+}
+
+// WatchSignal get the debounced changes of the wrapped device's pin.  An event is passed
+// on (with the time of the original edge) once the signal has held for the hold time.
+func (d *DebouncedRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	raw, err := d.device.WatchSignal(pin)
+	if err != nil {
+		return nil, err
+	}
+	debounced := make(chan SignalEvent, watchBufferSize)
+	go d.filterEvents(pin, raw, debounced)
+	return debounced, nil
+}
+
+// filterEvents pass on the raw events that hold for the pin's hold time
+func (d *DebouncedRPi) filterEvents(pin PiPin, raw <-chan SignalEvent, debounced chan<- SignalEvent) {
+	defer close(debounced)
+	config := d.pinConfig(pin)
+	stable := false
+	var pending *SignalEvent
+	var settled <-chan time.Time // nil (blocks forever) when there is no pending event
+
+	for {
+		select {
+		case event, ok := <-raw:
+			if !ok {
+				return
+			}
+			if event.Value() == stable {
+				if pending != nil {
+					d.countGlitch(pin)
+					log.Debugf("rejected %s glitch after %s", pin, event.Time.Sub(pending.Time))
+					pending, settled = nil, nil
+				}
+				continue
+			}
+			if pending == nil {
+				pending = &event
+				settled = d.clock.After(config.holdTime(event.Value()))
+			}
+		case <-settled:
+			stable = pending.Value()
+			debounced <- *pending
+			pending, settled = nil, nil
+		}
+	}
+}
+
+// GlitchCount the number of changes on pin that were rejected
+func (d *DebouncedRPi) GlitchCount(pin PiPin) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.glitches[pin]
+}
+
+// TotalGlitchCount the number of changes that were rejected on all pins
+func (d *DebouncedRPi) TotalGlitchCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	total := 0
+	for _, count := range d.glitches {
+		total += count
+	}
+	return total
+}
+
+func (d *DebouncedRPi) countGlitch(pin PiPin) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.glitches[pin]++
+}
+
+func (d *DebouncedRPi) pinConfig(pin PiPin) DebounceConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pinConfigLocked(pin)
+}
+
+// pinConfigLocked the caller must hold mu
+func (d *DebouncedRPi) pinConfigLocked(pin PiPin) DebounceConfig {
+	if config, ok := d.pinConfigs[pin]; ok {
+		return config
+	}
+	return d.config
+}
+
+// DebouncedRPi constructor setters for builder pattern
+
+// SetPinConfig debounce pin with its own config
+func (d *DebouncedRPi) SetPinConfig(pin PiPin, config DebounceConfig) *DebouncedRPi {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pinConfigs[pin] = config
+	return d
+}
+
+// SetClock used by testing to control time
+func (d *DebouncedRPi) SetClock(clock Clock) *DebouncedRPi {
+	d.clock = clock
+	return d
+}
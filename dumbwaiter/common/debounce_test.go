@@ -0,0 +1,137 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDebounceConfig = DebounceConfig{SettleTime: 20 * time.Millisecond, MinPulseWidth: 50 * time.Millisecond}
+
+// scriptedRPi returns the signal values the test sets, and passes the test's events to watchers
+type scriptedRPi struct {
+	values map[PiPin]bool
+	events chan SignalEvent
+	mu     sync.Mutex
+}
+
+func newScriptedRPi() *scriptedRPi {
+	return &scriptedRPi{values: map[PiPin]bool{}, events: make(chan SignalEvent, watchBufferSize)}
+}
+
+func (s *scriptedRPi) SendSignal(pin PiPin) error {
+	return nil
+}
+
+func (s *scriptedRPi) GetSignal(pin PiPin) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[pin], nil
+}
+
+func (s *scriptedRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	return s.events, nil
+}
+
+func (s *scriptedRPi) set(pin PiPin, value bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[pin] = value
+}
+
+// TestDebouncePolledPress a press is reported once it has held for the minimum pulse width
+func TestDebouncePolledPress(t *testing.T) {
+	raw := newScriptedRPi()
+	clock := NewFakeClock(time.Unix(0, 0))
+	debounced := NewDebouncedRPi(raw, testDebounceConfig).SetClock(clock)
+
+	raw.set(StopRequested, true)
+	assertSignal(t, debounced, StopRequested, false)
+	clock.Advance(30 * time.Millisecond) // settled, but shorter than the minimum pulse
+	assertSignal(t, debounced, StopRequested, false)
+	clock.Advance(20 * time.Millisecond)
+	assertSignal(t, debounced, StopRequested, true)
+
+	// release only needs to settle
+	raw.set(StopRequested, false)
+	assertSignal(t, debounced, StopRequested, true)
+	clock.Advance(20 * time.Millisecond)
+	assertSignal(t, debounced, StopRequested, false)
+	assert.Equal(t, 0, debounced.GlitchCount(StopRequested))
+}
+
+// TestDebouncePolledBounce bounces shorter than the hold time are counted and dropped
+func TestDebouncePolledBounce(t *testing.T) {
+	raw := newScriptedRPi()
+	clock := NewFakeClock(time.Unix(0, 0))
+	debounced := NewDebouncedRPi(raw, testDebounceConfig).SetClock(clock)
+
+	for i := 0; i < 3; i++ {
+		raw.set(Floor2Requested, true)
+		assertSignal(t, debounced, Floor2Requested, false)
+		clock.Advance(5 * time.Millisecond)
+		raw.set(Floor2Requested, false)
+		assertSignal(t, debounced, Floor2Requested, false)
+		clock.Advance(5 * time.Millisecond)
+	}
+	assert.Equal(t, 3, debounced.GlitchCount(Floor2Requested))
+	assert.Equal(t, 3, debounced.TotalGlitchCount())
+}
+
+// TestDebouncePinConfig a pin can have its own hold times
+func TestDebouncePinConfig(t *testing.T) {
+	raw := newScriptedRPi()
+	clock := NewFakeClock(time.Unix(0, 0))
+	debounced := NewDebouncedRPi(raw, testDebounceConfig).SetClock(clock).
+		SetPinConfig(AtFloor, DebounceConfig{SettleTime: 5 * time.Millisecond})
+
+	raw.set(AtFloor, true)
+	assertSignal(t, debounced, AtFloor, false)
+	clock.Advance(5 * time.Millisecond)
+	assertSignal(t, debounced, AtFloor, true)
+}
+
+// TestDebounceWatchedSignal watched events are held back until they settle, glitches are dropped
+func TestDebounceWatchedSignal(t *testing.T) {
+	raw := newScriptedRPi()
+	clock := NewFakeClock(time.Unix(0, 0))
+	debounced := NewDebouncedRPi(raw, testDebounceConfig).SetClock(clock)
+	events, err := debounced.WatchSignal(AtFloor)
+	assert.NoError(t, err)
+
+	// a 10ms noise pulse
+	pressTime := clock.Now()
+	raw.events <- SignalEvent{Pin: AtFloor, Edge: Rising, Time: pressTime}
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Millisecond)
+	raw.events <- SignalEvent{Pin: AtFloor, Edge: Falling, Time: clock.Now()}
+
+	// a real arrival
+	arriveTime := clock.Now()
+	raw.events <- SignalEvent{Pin: AtFloor, Edge: Rising, Time: arriveTime}
+	clock.BlockUntil(2) // the glitch's abandoned wait is still registered
+	clock.Advance(50 * time.Millisecond)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, Rising, event.Edge)
+		assert.Equal(t, arriveTime, event.Time, "the event should keep the time of the original edge")
+	case <-time.After(time.Second):
+		assert.Fail(t, "debounced arrival event not received")
+	}
+	select {
+	case event := <-events:
+		assert.Fail(t, "unexpected event", "%v", event)
+	default:
+	}
+	assert.Equal(t, 1, debounced.GlitchCount(AtFloor))
+}
+
+func assertSignal(t *testing.T, rpi RPi, pin PiPin, expected bool) {
+	t.Helper()
+	value, err := rpi.GetSignal(pin)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, value, "%s signal", pin)
+}
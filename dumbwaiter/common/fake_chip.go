@@ -0,0 +1,159 @@
+package common
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeChip in memory GPIOChip used for testing RPiDevice on machines without gpio hardware.
+// Tests drive input lines with SetInput and check output lines with Output, both work with
+// the physical line level (before any active low inversion).
+type FakeChip struct {
+	numLines  int
+	values    map[int]bool
+	requested map[int]LineConfig
+	watched   map[int]chan LineEvent
+	closed    bool
+	mu        sync.RWMutex
+}
+
+// NewFakeChip create a fake chip with numLines lines, all inactive
+func NewFakeChip(numLines int) *FakeChip {
+	return &FakeChip{
+		numLines:  numLines,
+		values:    map[int]bool{},
+		requested: map[int]LineConfig{},
+		watched:   map[int]chan LineEvent{},
+	}
+}
+
+// RequestLine request exclusive use of a line, fails like the kernel if the line is out of range or busy
+func (f *FakeChip) RequestLine(offset int, config LineConfig) (GPIOLine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.request(offset, config); err != nil {
+		return nil, err
+	}
+	return &fakeLine{chip: f, offset: offset}, nil
+}
+
+// WatchLine request exclusive use of an input line, SetInput level changes are reported as events
+func (f *FakeChip) WatchLine(offset int, config LineConfig) (GPIOEventLine, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if config.Direction != Input {
+		return nil, fmt.Errorf("line %d: only input lines have events", offset)
+	}
+	if err := f.request(offset, config); err != nil {
+		return nil, err
+	}
+	events := make(chan LineEvent, watchBufferSize)
+	f.watched[offset] = events
+	return &fakeEventLine{fakeLine: fakeLine{chip: f, offset: offset}, events: events}, nil
+}
+
+// request mark a line as in use, the caller must hold mu
+func (f *FakeChip) request(offset int, config LineConfig) error {
+	if f.closed {
+		return fmt.Errorf("fake chip is closed")
+	}
+	if offset < 0 || offset >= f.numLines {
+		return fmt.Errorf("line %d out of range, chip has %d lines", offset, f.numLines)
+	}
+	if _, busy := f.requested[offset]; busy {
+		return fmt.Errorf("line %d is busy", offset)
+	}
+	f.requested[offset] = config
+	if config.Direction == Output {
+		f.values[offset] = config.Initial != config.ActiveLow
+	} else if _, driven := f.values[offset]; !driven {
+		f.values[offset] = config.Bias == BiasPullUp
+	}
+	return nil
+}
+
+// Close release the chip
+func (f *FakeChip) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+// SetInput simulate an external signal driving a line to level
+func (f *FakeChip) SetInput(offset int, level bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.values[offset] == level {
+		return
+	}
+	f.values[offset] = level
+	if events, ok := f.watched[offset]; ok {
+		events <- LineEvent{Rising: level != f.requested[offset].ActiveLow, Time: time.Now()}
+	}
+}
+
+// Output get the level the line is currently at
+func (f *FakeChip) Output(offset int) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.values[offset]
+}
+
+// Requested get the config a line was requested with, false if the line has not been requested
+func (f *FakeChip) Requested(offset int) (LineConfig, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	config, ok := f.requested[offset]
+	return config, ok
+}
+
+type fakeLine struct {
+	chip   *FakeChip
+	offset int
+	closed bool
+}
+
+func (l *fakeLine) Value() (bool, error) {
+	if l.closed {
+		return false, fmt.Errorf("line %d is closed", l.offset)
+	}
+	config, _ := l.chip.Requested(l.offset)
+	return l.chip.Output(l.offset) != config.ActiveLow, nil
+}
+
+func (l *fakeLine) SetValue(value bool) error {
+	if l.closed {
+		return fmt.Errorf("line %d is closed", l.offset)
+	}
+	config, _ := l.chip.Requested(l.offset)
+	if config.Direction != Output {
+		return fmt.Errorf("line %d is not an output", l.offset)
+	}
+	l.chip.mu.Lock()
+	defer l.chip.mu.Unlock()
+	l.chip.values[l.offset] = value != config.ActiveLow
+	return nil
+}
+
+func (l *fakeLine) Close() error {
+	l.chip.mu.Lock()
+	defer l.chip.mu.Unlock()
+	delete(l.chip.requested, l.offset)
+	if events, ok := l.chip.watched[l.offset]; ok {
+		close(events)
+		delete(l.chip.watched, l.offset)
+	}
+	l.closed = true
+	return nil
+}
+
+type fakeEventLine struct {
+	fakeLine
+	events chan LineEvent
+}
+
+func (l *fakeEventLine) Events() <-chan LineEvent {
+	return l.events
+}
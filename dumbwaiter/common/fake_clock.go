@@ -0,0 +1,79 @@
+package common
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock a Clock that only moves when the test calls Advance
+type FakeClock struct {
+	now     time.Time
+	waiters []fakeWaiter
+	mu      sync.Mutex
+	changed *sync.Cond
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+// NewFakeClock create a fake clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	f := &FakeClock{now: start}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now get the fake time
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After get a channel that fires once the clock has been advanced by d
+func (f *FakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, fakeWaiter{until: f.now.Add(d), ch: ch})
+	f.changed.Broadcast()
+	return ch
+}
+
+// Sleep block until the clock has been advanced by d
+func (f *FakeClock) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance move the clock forward by d, firing the waiters that come due in time order
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].until.Before(f.waiters[j].until) })
+	for len(f.waiters) > 0 && !f.waiters[0].until.After(end) {
+		waiter := f.waiters[0]
+		f.waiters = f.waiters[1:]
+		f.now = waiter.until
+		waiter.ch <- f.now
+	}
+	f.now = end
+	f.changed.Broadcast()
+}
+
+// BlockUntil wait until n goroutines are waiting on the clock, so a test can advance the
+// clock knowing the code under test has started its wait
+func (f *FakeClock) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
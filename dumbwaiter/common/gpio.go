@@ -0,0 +1,74 @@
+package common
+
+/*
+gpio.go defines the line level access to a gpio chip.  The production implementation
+uses the linux gpio character device (/dev/gpiochipN), the FakeChip implementation
+keeps the line values in memory so RPiDevice can be tested without gpio hardware.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultChipPath the gpio character device for the RPi B+ header pins
+const DefaultChipPath = "/dev/gpiochip0"
+
+// consumerLabel the label the kernel shows as the owner of the lines we request
+const consumerLabel = "dumbwaiter"
+
+// LineDirection whether a gpio line is read or driven
+type LineDirection int
+
+// LineDirection constants are Input and Output.
+const (
+	Input LineDirection = iota
+	Output
+)
+
+func (d LineDirection) String() string {
+	return [...]string{"input", "output"}[d]
+}
+
+// LineConfig how a gpio line should be requested from the chip
+type LineConfig struct {
+	Direction LineDirection
+	ActiveLow bool     // the line reads (and is driven) as active when it is low
+	Bias      LineBias // pull up/down resistor setting
+	Initial   bool     // the value an output line is set to when it is requested
+}
+
+// GPIOChip the interface functions for requesting lines from a gpio chip
+type GPIOChip interface {
+	RequestLine(offset int, config LineConfig) (GPIOLine, error)    // request exclusive use of a line
+	WatchLine(offset int, config LineConfig) (GPIOEventLine, error) // request exclusive use of an input line with edge events
+	Close() error                                                   // release the chip
+}
+
+// GPIOLine the interface functions for reading and driving a single requested line
+type GPIOLine interface {
+	Value() (bool, error)      // read the line, true when the line is active
+	SetValue(value bool) error // drive an output line
+	Close() error              // release the line back to the chip
+}
+
+// GPIOEventLine an input line that also reports its edges
+type GPIOEventLine interface {
+	GPIOLine
+	Events() <-chan LineEvent // the line's edges, closed when the line is closed
+}
+
+// LineEvent an edge reported by the chip
+type LineEvent struct {
+	Rising bool      // true when the line became active, false when it became inactive
+	Time   time.Time // when the chip saw the edge
+}
+
+// OpenChip open the gpio character device at path
+func OpenChip(path string) (GPIOChip, error) {
+	chip, err := openCdevChip(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening gpio chip %s: %w", path, err)
+	}
+	return chip, nil
+}
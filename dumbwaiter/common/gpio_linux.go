@@ -0,0 +1,270 @@
+//go:build linux
+// +build linux
+
+package common
+
+/*
+gpio_linux.go implements GPIOChip with the (v1) linux gpio character device ioctls
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// gpio character device abi, see include/uapi/linux/gpio.h
+const (
+	gpioHandlesMax = 64
+
+	gpioGetChipInfoIoctl         = 0x8044b401
+	gpioGetLineHandleIoctl       = 0xc16cb403
+	gpioGetLineEventIoctl        = 0xc030b404
+	gpioHandleGetLineValuesIoctl = 0xc040b408
+	gpioHandleSetLineValuesIoctl = 0xc040b409
+
+	gpioHandleRequestInput        = 1 << 0
+	gpioHandleRequestOutput       = 1 << 1
+	gpioHandleRequestActiveLow    = 1 << 2
+	gpioHandleRequestBiasPullUp   = 1 << 5
+	gpioHandleRequestBiasPullDown = 1 << 6
+	gpioHandleRequestBiasDisable  = 1 << 7
+
+	gpioEventRequestBothEdges = 3
+	gpioEventRisingEdge       = 1
+
+	clockMonotonic = 1
+)
+
+type gpioChipInfo struct {
+	name  [32]byte
+	label [32]byte
+	lines uint32
+}
+
+type gpioHandleRequest struct {
+	lineOffsets   [gpioHandlesMax]uint32
+	flags         uint32
+	defaultValues [gpioHandlesMax]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [gpioHandlesMax]uint8
+}
+
+type gpioEventRequest struct {
+	lineOffset    uint32
+	handleFlags   uint32
+	eventFlags    uint32
+	consumerLabel [32]byte
+	fd            int32
+}
+
+type gpioEventData struct {
+	timestamp uint64 // CLOCK_MONOTONIC nanoseconds
+	id        uint32
+	_         uint32
+}
+
+// cdevChip a gpio chip opened through its character device
+type cdevChip struct {
+	f     *os.File
+	lines uint32
+	mu    sync.Mutex
+}
+
+func openCdevChip(path string) (*cdevChip, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var info gpioChipInfo
+	if err := ioctl(f.Fd(), gpioGetChipInfoIoctl, unsafe.Pointer(&info)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading chip info: %w", err)
+	}
+	return &cdevChip{f: f, lines: info.lines}, nil
+}
+
+// RequestLine request a single line handle from the kernel
+func (c *cdevChip) RequestLine(offset int, config LineConfig) (GPIOLine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset < 0 || uint32(offset) >= c.lines {
+		return nil, fmt.Errorf("line %d out of range, chip has %d lines", offset, c.lines)
+	}
+
+	var req gpioHandleRequest
+	req.lineOffsets[0] = uint32(offset)
+	req.lines = 1
+	copy(req.consumerLabel[:], consumerLabel)
+	req.flags = handleFlags(config)
+	if config.Direction == Output {
+		req.defaultValues[0] = boolToByte(config.Initial)
+	}
+	if err := ioctl(c.f.Fd(), gpioGetLineHandleIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("requesting line %d: %w", offset, err)
+	}
+	return &cdevLine{fd: uintptr(req.fd), offset: offset}, nil
+}
+
+// WatchLine request a single input line with both edge events from the kernel
+func (c *cdevChip) WatchLine(offset int, config LineConfig) (GPIOEventLine, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset < 0 || uint32(offset) >= c.lines {
+		return nil, fmt.Errorf("line %d out of range, chip has %d lines", offset, c.lines)
+	}
+	if config.Direction != Input {
+		return nil, fmt.Errorf("line %d: only input lines have events", offset)
+	}
+
+	var req gpioEventRequest
+	req.lineOffset = uint32(offset)
+	req.handleFlags = handleFlags(config)
+	req.eventFlags = gpioEventRequestBothEdges
+	copy(req.consumerLabel[:], consumerLabel)
+	if err := ioctl(c.f.Fd(), gpioGetLineEventIoctl, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("requesting events for line %d: %w", offset, err)
+	}
+
+	// a non blocking fd lets the runtime poller wake the reader when the line is closed
+	fd := uintptr(req.fd)
+	if err := syscall.SetNonblock(int(fd), true); err != nil {
+		syscall.Close(int(fd))
+		return nil, fmt.Errorf("requesting events for line %d: %w", offset, err)
+	}
+	line := &cdevEventLine{
+		cdevLine: cdevLine{fd: fd, offset: offset},
+		f:        os.NewFile(fd, fmt.Sprintf("gpio-line-%d", offset)),
+		events:   make(chan LineEvent, watchBufferSize),
+	}
+	go line.readEvents()
+	return line, nil
+}
+
+// Close release the chip's file descriptor (lines already requested stay valid)
+func (c *cdevChip) Close() error {
+	return c.f.Close()
+}
+
+// cdevLine a line handle returned by the kernel
+type cdevLine struct {
+	fd     uintptr
+	offset int
+}
+
+// Value read the line's current value
+func (l *cdevLine) Value() (bool, error) {
+	var data gpioHandleData
+	if err := ioctl(l.fd, gpioHandleGetLineValuesIoctl, unsafe.Pointer(&data)); err != nil {
+		return false, fmt.Errorf("reading line %d: %w", l.offset, err)
+	}
+	return data.values[0] != 0, nil
+}
+
+// SetValue drive the line to value
+func (l *cdevLine) SetValue(value bool) error {
+	var data gpioHandleData
+	data.values[0] = boolToByte(value)
+	if err := ioctl(l.fd, gpioHandleSetLineValuesIoctl, unsafe.Pointer(&data)); err != nil {
+		return fmt.Errorf("setting line %d: %w", l.offset, err)
+	}
+	return nil
+}
+
+// Close release the line handle
+func (l *cdevLine) Close() error {
+	return syscall.Close(int(l.fd))
+}
+
+// handleFlags translate a line config into the kernel's line handle request flags
+func handleFlags(config LineConfig) uint32 {
+	var flags uint32 = gpioHandleRequestInput
+	if config.Direction == Output {
+		flags = gpioHandleRequestOutput
+	}
+	if config.ActiveLow {
+		flags |= gpioHandleRequestActiveLow
+	}
+	switch config.Bias {
+	case BiasDisable:
+		flags |= gpioHandleRequestBiasDisable
+	case BiasPullUp:
+		flags |= gpioHandleRequestBiasPullUp
+	case BiasPullDown:
+		flags |= gpioHandleRequestBiasPullDown
+	}
+	return flags
+}
+
+// cdevEventLine a line event handle returned by the kernel, values are read through
+// the embedded cdevLine, edges through the file
+type cdevEventLine struct {
+	cdevLine
+	f      *os.File
+	events chan LineEvent
+}
+
+// Events get the line's edges
+func (l *cdevEventLine) Events() <-chan LineEvent {
+	return l.events
+}
+
+// Close release the line event handle, this ends the event stream
+func (l *cdevEventLine) Close() error {
+	return l.f.Close()
+}
+
+// readEvents read the kernel's edge events until the line is closed
+func (l *cdevEventLine) readEvents() {
+	defer close(l.events)
+	var data gpioEventData
+	buf := (*[unsafe.Sizeof(data)]byte)(unsafe.Pointer(&data))[:]
+	for {
+		n, err := l.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Errorf("error reading line %d events: %v", l.offset, err)
+			}
+			return
+		}
+		if n != len(buf) {
+			continue
+		}
+		l.events <- LineEvent{Rising: data.id == gpioEventRisingEdge, Time: monotonicToTime(data.timestamp)}
+	}
+}
+
+// monotonicToTime convert a kernel CLOCK_MONOTONIC timestamp to wall clock time
+func monotonicToTime(nanos uint64) time.Time {
+	now := time.Now()
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return now
+	}
+	return now.Add(-time.Duration(ts.Nano() - int64(nanos)))
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func boolToByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
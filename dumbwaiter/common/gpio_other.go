@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package common
+
+import "errors"
+
+type cdevChip struct{}
+
+func openCdevChip(path string) (*cdevChip, error) {
+	return nil, errors.New("the gpio character device is only available on linux")
+}
+
+// RequestLine not supported off linux
+func (c *cdevChip) RequestLine(offset int, config LineConfig) (GPIOLine, error) {
+	return nil, errors.New("the gpio character device is only available on linux")
+}
+
+// WatchLine not supported off linux
+func (c *cdevChip) WatchLine(offset int, config LineConfig) (GPIOEventLine, error) {
+	return nil, errors.New("the gpio character device is only available on linux")
+}
+
+// Close noop
+func (c *cdevChip) Close() error {
+	return nil
+}
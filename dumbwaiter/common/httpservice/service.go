@@ -57,7 +57,7 @@ func (s *Service) RunService() {
 // addCommonEndpoints add endpoints common to all of the services
 func (s *Service) addCommonEndpoints(router *mux.Router) {
 	log.Info("adding standard endpoints")
-	router.HandleFunc(fmt.Sprintf("%s/health", s.serviceName), s.HealthEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/health", s.serviceName), s.HealthEndpoint).Methods("GET")
 }
 
 // HealthEndpoint will return ok if the service is running, otherwise the caller should get 404
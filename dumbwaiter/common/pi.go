@@ -5,10 +5,62 @@ package common
 pi.go implements the interface with the RPi B+ machine
 */
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
 // RPi the interface functions for getting/sending signal on the gpio pins
 type RPi interface {
-	SendSignal(pin PiPin) error        // send a signal on the target pin
-	GetSignal(pin PiPin) (bool, error) // get a signal from a target pin, true when signal is on, false when off
+	SendSignal(pin PiPin) error                        // send a signal on the target pin
+	GetSignal(pin PiPin) (bool, error)                 // get a signal from a target pin, true when signal is on, false when off
+	WatchSignal(pin PiPin) (<-chan SignalEvent, error) // get the target pin's signal changes as they happen
+}
+
+// LevelRPi a RPi whose output pins can be turned back off
+type LevelRPi interface {
+	RPi
+	SetSignal(pin PiPin, value bool) error // turn the target output pin on or off
+}
+
+// Preempter a RPi that can send a signal ahead of the ones already in progress
+type Preempter interface {
+	Preempt(pin PiPin) error // send a signal on the target pin now, cutting short any in progress
+}
+
+// ErrWatchNotSupported returned by WatchSignal when a RPi can only be polled
+var ErrWatchNotSupported = errors.New("watching signals is not supported")
+
+// watchBufferSize the number of events a watcher can fall behind by before events are dropped
+const watchBufferSize = 64
+
+// Edge the direction of a signal change
+type Edge int
+
+// Edge constants are Rising (the signal turned on) and Falling (the signal turned off).
+const (
+	Rising Edge = iota
+	Falling
+)
+
+func (e Edge) String() string {
+	return [...]string{"rising", "falling"}[e]
+}
+
+// SignalEvent a change of a pin's signal
+type SignalEvent struct {
+	Pin  PiPin
+	Edge Edge
+	Time time.Time // when the signal changed
+}
+
+// Value the pin's signal after the change
+func (e SignalEvent) Value() bool {
+	return e.Edge == Rising
 }
 
 type PiPin int
@@ -22,30 +74,294 @@ const (
 	Floor3Requested
 	StopRequested
 	AtFloor
+	OpenerButton     // relay across a single button opener's wall button
+	MotorForward     // h-bridge forward direction input
+	MotorReverse     // h-bridge reverse direction input
+	MotorEnable      // h-bridge enable, pwm on it sets the motor speed
+	ContactorUp      // up contactor coil
+	ContactorDown    // down contactor coil
+	StepperStep      // stepper driver step input, one pulse per step
+	StepperDirection // stepper driver direction input, on for up
+	StepperEnable    // stepper driver enable input
+	StepperHome      // home switch, on when the car is at the bottom floor
+	TopLimit         // limit switch above the top floor, on when the car has gone past it
+	BottomLimit      // limit switch below the bottom floor, on when the car has gone past it
+	numNamedPins     // the call buttons of floors above 3 follow the named pins, see FloorRequested
 )
 
+var piPinNames = [...]string{"OpenerUp", "OpenerDown", "OpenerStop", "Floor1Requested", "Floor2Requested", "Floor3Requested", "StopRequested", "AtFloor",
+	"OpenerButton", "MotorForward", "MotorReverse", "MotorEnable", "ContactorUp", "ContactorDown",
+	"StepperStep", "StepperDirection", "StepperEnable", "StepperHome",
+	"TopLimit", "BottomLimit"}
+
 func (p PiPin) String() string {
-	return [...]string{"OpenerUp", "OpenerDown", "OpenerStop", "Floor1Requested", "Floor2Requested", "Floor3Requested", "StopRequested", "AtFloor"}[p]
+	if floor, ok := p.RequestedFloor(); ok {
+		return fmt.Sprintf("Floor%dRequested", floor)
+	}
+	if p < 0 {
+		return fmt.Sprintf("PiPin(%d)", int(p))
+	}
+	return piPinNames[p]
+}
+
+// FloorRequested the call button pin for floor (numbered from 1), there is one for every floor
+func FloorRequested(floor int) PiPin {
+	if floor <= 3 {
+		return Floor1Requested + PiPin(floor-1)
+	}
+	return numNamedPins + PiPin(floor-4)
 }
 
-// TODO implement the PI interfaces
+// FloorRequestPins the call buttons for floors 1 through numFloors, floor 1's button first
+func FloorRequestPins(numFloors int) []PiPin {
+	pins := make([]PiPin, numFloors)
+	for i := range pins {
+		pins[i] = FloorRequested(i + 1)
+	}
+	return pins
+}
+
+// RequestedFloor the floor a call button pin calls the car to, false for the other pins
+func (p PiPin) RequestedFloor() (int, bool) {
+	if p >= Floor1Requested && p <= Floor3Requested {
+		return int(p-Floor1Requested) + 1, true
+	}
+	if p >= numNamedPins {
+		return int(p-numNamedPins) + 4, true
+	}
+	return 0, false
+}
 
-// RPiDevice communicates with the RPi B+ device
-type RPiDevice struct{}
+// ParsePiPin get the pin with the given name
+func ParsePiPin(name string) (PiPin, error) {
+	for p, pinName := range piPinNames {
+		if pinName == name {
+			return PiPin(p), nil
+		}
+	}
+	var floor int
+	if _, err := fmt.Sscanf(name, "Floor%dRequested", &floor); err == nil && floor > 0 && FloorRequested(floor).String() == name {
+		return FloorRequested(floor), nil
+	}
+	return 0, fmt.Errorf("unknown pin %q", name)
+}
 
-// NewRPiDevice return an instance of a RPiDevice
-func NewRPiDevice() *RPiDevice {
-	return &RPiDevice{}
+// MarshalText write the pin by name, so pins can be used as json keys
+func (p PiPin) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText read the pin by name
+func (p *PiPin) UnmarshalText(text []byte) error {
+	pin, err := ParsePiPin(string(text))
+	if err != nil {
+		return err
+	}
+	*p = pin
+	return nil
 }
 
-// SendSignal send a signal on the selected pin to the RPi B+ device
+// RPiDevice communicates with the RPi B+ device through the linux gpio character device.
+// The chip is opened and the lines are requested on first use (or by Open).
+type RPiDevice struct {
+	pinMap   *PinMap
+	chip     GPIOChip
+	lines    map[PiPin]GPIOLine
+	watchers map[PiPin][]chan SignalEvent
+	mu       sync.Mutex
+}
+
+// NewRPiDevice return an instance of a RPiDevice wired as described by pinMap
+func NewRPiDevice(pinMap *PinMap) *RPiDevice {
+	return &RPiDevice{
+		pinMap:   pinMap,
+		lines:    map[PiPin]GPIOLine{},
+		watchers: map[PiPin][]chan SignalEvent{},
+	}
+}
+
+// Open request all of the mapped lines, so wiring problems show up at startup
+// rather than on the first signal
+func (r *RPiDevice) Open() error {
+	if err := r.pinMap.Validate(); err != nil {
+		return err
+	}
+	for pin := range r.pinMap.Pins {
+		if _, err := r.line(pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendSignal send a signal on the selected pin to the RPi B+ device, the pin is left on
 func (r *RPiDevice) SendSignal(pin PiPin) error {
-	// TODO implement
+	if err := r.checkOutput(pin, "send a signal on"); err != nil {
+		return err
+	}
+	line, err := r.line(pin)
+	if err != nil {
+		return err
+	}
+	return line.SetValue(true)
+}
+
+// SetSignal turn the selected output pin on or off
+func (r *RPiDevice) SetSignal(pin PiPin, value bool) error {
+	if err := r.checkOutput(pin, "set a signal on"); err != nil {
+		return err
+	}
+	line, err := r.line(pin)
+	if err != nil {
+		return err
+	}
+	return line.SetValue(value)
+}
+
+// checkOutput nil when pin is mapped to an output line, otherwise why op can't be done on it
+func (r *RPiDevice) checkOutput(pin PiPin, op string) error {
+	config, ok := r.pinMap.Pins[pin]
+	if !ok {
+		return fmt.Errorf("can't %s unmapped pin %s, no gpio line assigned", op, pin)
+	}
+	if config.Direction != Output {
+		return fmt.Errorf("can't %s input pin %s", op, pin)
+	}
 	return nil
 }
 
 // GetSignal get a signal from the selected pin on the RPi B+ device
 func (r *RPiDevice) GetSignal(pin PiPin) (bool, error) {
-	// TODO implement
-	return false, nil
+	line, err := r.line(pin)
+	if err != nil {
+		return false, err
+	}
+	return line.Value()
+}
+
+// WatchSignal get the kernel's edge events for an input pin.  The returned channel is
+// closed when the device is closed.
+func (r *RPiDevice) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	config, ok := r.pinMap.Pins[pin]
+	if !ok {
+		return nil, fmt.Errorf("no gpio line assigned to %s", pin)
+	}
+	if config.Direction != Input {
+		return nil, fmt.Errorf("can't watch output pin %s", pin)
+	}
+
+	if _, watched := r.watchers[pin]; !watched {
+		if err := r.openChip(); err != nil {
+			return nil, err
+		}
+		// the line can only be requested once, so swap a plain line for an event line
+		if line, ok := r.lines[pin]; ok {
+			line.Close()
+			delete(r.lines, pin)
+		}
+		eventLine, err := r.chip.WatchLine(config.Line, config.lineConfig())
+		if err != nil {
+			return nil, fmt.Errorf("watching %s: %w", pin, err)
+		}
+		r.lines[pin] = eventLine
+		r.watchers[pin] = nil
+		go r.forwardEvents(pin, eventLine.Events())
+	}
+
+	watcher := make(chan SignalEvent, watchBufferSize)
+	r.watchers[pin] = append(r.watchers[pin], watcher)
+	return watcher, nil
+}
+
+// forwardEvents pass a line's events on to the pin's watchers
+func (r *RPiDevice) forwardEvents(pin PiPin, events <-chan LineEvent) {
+	for event := range events {
+		signalEvent := SignalEvent{Pin: pin, Edge: Falling, Time: event.Time}
+		if event.Rising {
+			signalEvent.Edge = Rising
+		}
+		r.mu.Lock()
+		for _, watcher := range r.watchers[pin] {
+			select {
+			case watcher <- signalEvent:
+			default:
+				log.Warnf("%s watcher is not keeping up, dropped %s event", pin, signalEvent.Edge)
+			}
+		}
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, watcher := range r.watchers[pin] {
+		close(watcher)
+	}
+	delete(r.watchers, pin)
+}
+
+// Close release the requested lines and the chip
+func (r *RPiDevice) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for pin, line := range r.lines {
+		if err := line.Close(); err != nil {
+			log.Errorf("error releasing %s line: %v", pin, err)
+		}
+		delete(r.lines, pin)
+	}
+	if r.chip == nil {
+		return nil
+	}
+	err := r.chip.Close()
+	r.chip = nil
+	return err
+}
+
+// line get the requested line for pin, opening the chip and requesting the line as needed
+func (r *RPiDevice) line(pin PiPin) (GPIOLine, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if line, ok := r.lines[pin]; ok {
+		return line, nil
+	}
+	config, ok := r.pinMap.Pins[pin]
+	if !ok {
+		return nil, fmt.Errorf("no gpio line assigned to %s", pin)
+	}
+	if err := r.openChip(); err != nil {
+		return nil, err
+	}
+	line, err := r.chip.RequestLine(config.Line, config.lineConfig())
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", pin, err)
+	}
+	r.lines[pin] = line
+	return line, nil
+}
+
+// openChip open the gpio chip if it isn't already open, the caller must hold mu
+func (r *RPiDevice) openChip() error {
+	if r.chip != nil {
+		return nil
+	}
+	chipPath := r.pinMap.Chip
+	if chipPath == "" {
+		chipPath = DefaultChipPath
+	}
+	chip, err := OpenChip(chipPath)
+	if err != nil {
+		return err
+	}
+	r.chip = chip
+	return nil
+}
+
+// RPiDevice constructor setters for builder pattern
+
+// SetChip used by testing to override the gpio character device
+func (r *RPiDevice) SetChip(chip GPIOChip) *RPiDevice {
+	r.chip = chip
+	return r
 }
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSendSignalDrivesOutputLine sending a signal drives the pin's output line active
+func TestSendSignalDrivesOutputLine(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+
+	assert.NoError(t, device.SendSignal(OpenerUp))
+	assert.True(t, chip.Output(lineOf(OpenerUp)), "opener up line should be active")
+	config, ok := chip.Requested(lineOf(OpenerUp))
+	assert.True(t, ok, "opener up line should be requested")
+	assert.Equal(t, Output, config.Direction)
+}
+
+// TestGetSignalReadsInputLine getting a signal reads the pin's input line
+func TestGetSignalReadsInputLine(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+
+	value, err := device.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.False(t, value)
+
+	chip.SetInput(lineOf(AtFloor), true)
+	value, err = device.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.True(t, value)
+	config, _ := chip.Requested(lineOf(AtFloor))
+	assert.Equal(t, Input, config.Direction)
+}
+
+// TestSendSignalOnInputPin sending on an input pin is an error and does not request the line
+func TestSendSignalOnInputPin(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+
+	assert.Error(t, device.SendSignal(StopRequested))
+	_, ok := chip.Requested(lineOf(StopRequested))
+	assert.False(t, ok)
+}
+
+// TestSendSignalOnUnmappedPin a pin missing from the pin map is reported as unmapped
+func TestSendSignalOnUnmappedPin(t *testing.T) {
+	pinMap := DefaultPinMap()
+	delete(pinMap.Pins, OpenerUp)
+	device := NewRPiDevice(pinMap).SetChip(NewFakeChip(32))
+
+	err := device.SendSignal(OpenerUp)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unmapped pin OpenerUp")
+	assert.Contains(t, device.SetSignal(OpenerUp, false).Error(), "unmapped pin OpenerUp")
+}
+
+// TestLineRequestFailure errors from the chip are returned to the caller
+func TestLineRequestFailure(t *testing.T) {
+	chip := NewFakeChip(8) // too few lines for the default pin assignments
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+
+	_, err := device.GetSignal(AtFloor)
+	assert.Error(t, err)
+}
+
+// TestCloseReleasesLines closing the device releases its lines so they can be requested again
+func TestCloseReleasesLines(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+	assert.NoError(t, device.SendSignal(OpenerStop))
+
+	assert.NoError(t, device.Close())
+	_, ok := chip.Requested(lineOf(OpenerStop))
+	assert.False(t, ok)
+}
+
+// TestOpenOnlyRolePins a floor opening its own pins leaves the drive's lines unclaimed
+func TestOpenOnlyRolePins(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap().Only(FloorPins(3)...)).SetChip(chip)
+	assert.NoError(t, device.Open())
+
+	_, ok := chip.Requested(lineOf(AtFloor))
+	assert.True(t, ok)
+	for _, pin := range []PiPin{OpenerUp, OpenerButton, MotorEnable, ContactorUp, StepperStep} {
+		_, ok := chip.Requested(lineOf(pin))
+		assert.False(t, ok, pin.String())
+	}
+}
+
+// TestActiveLowLines active low pins read and drive inverted line levels
+func TestActiveLowLines(t *testing.T) {
+	chip := NewFakeChip(32)
+	pinMap := &PinMap{Pins: map[PiPin]PinConfig{
+		OpenerUp: {Line: 2, Direction: Output, ActiveLow: true},
+		AtFloor:  {Line: 3, Direction: Input, ActiveLow: true, Bias: BiasPullUp},
+	}}
+	device := NewRPiDevice(pinMap).SetChip(chip)
+	assert.NoError(t, device.Open())
+
+	assert.True(t, chip.Output(2), "inactive active low output should be high")
+	assert.NoError(t, device.SendSignal(OpenerUp))
+	assert.False(t, chip.Output(2), "active active low output should be low")
+
+	value, err := device.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.False(t, value, "pulled up active low input should be off")
+	chip.SetInput(3, false)
+	value, err = device.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.True(t, value, "grounded active low input should be on")
+}
+
+// lineOf the default line for pin
+func lineOf(pin PiPin) int {
+	return DefaultPinMap().Pins[pin].Line
+}
+
+// TestWatchSignal a watched input pin reports its edges and can still be read
+func TestWatchSignal(t *testing.T) {
+	chip := NewFakeChip(32)
+	device := NewRPiDevice(DefaultPinMap()).SetChip(chip)
+	_, err := device.GetSignal(AtFloor) // the plain line is swapped for an event line
+	assert.NoError(t, err)
+
+	events, err := device.WatchSignal(AtFloor)
+	assert.NoError(t, err)
+
+	chip.SetInput(lineOf(AtFloor), true)
+	event := <-events
+	assert.Equal(t, AtFloor, event.Pin)
+	assert.Equal(t, Rising, event.Edge)
+	value, err := device.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.True(t, value)
+
+	chip.SetInput(lineOf(AtFloor), false)
+	event = <-events
+	assert.Equal(t, Falling, event.Edge)
+
+	assert.NoError(t, device.Close())
+	_, open := <-events
+	assert.False(t, open, "closing the device should end the events")
+}
+
+// TestWatchOutputSignal output pins can't be watched
+func TestWatchOutputSignal(t *testing.T) {
+	device := NewRPiDevice(DefaultPinMap()).SetChip(NewFakeChip(32))
+	_, err := device.WatchSignal(OpenerUp)
+	assert.Error(t, err)
+}
+
+// TestFloorRequestedPins every floor has a call button pin, named for its floor
+func TestFloorRequestedPins(t *testing.T) {
+	assert.Equal(t, []PiPin{Floor1Requested, Floor2Requested, Floor3Requested}, FloorRequestPins(3))
+	for floor := 1; floor <= 12; floor++ {
+		pin := FloorRequested(floor)
+		name := fmt.Sprintf("Floor%dRequested", floor)
+		assert.Equal(t, name, pin.String())
+		parsed, err := ParsePiPin(name)
+		assert.NoError(t, err)
+		assert.Equal(t, pin, parsed)
+		requested, ok := pin.RequestedFloor()
+		assert.True(t, ok)
+		assert.Equal(t, floor, requested)
+	}
+	_, ok := AtFloor.RequestedFloor()
+	assert.False(t, ok)
+	assert.Equal(t, "StepperHome", StepperHome.String())
+	assert.Equal(t, "BottomLimit", BottomLimit.String())
+	assert.Equal(t, "PiPin(-1)", PiPin(-1).String())
+
+	for _, name := range []string{"Floor0Requested", "Floor04Requested", "FloorRequested"} {
+		_, err := ParsePiPin(name)
+		assert.Error(t, err, name)
+	}
+}
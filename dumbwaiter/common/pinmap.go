@@ -0,0 +1,199 @@
+package common
+
+/*
+pinmap.go maps the logical PiPins onto the gpio lines they are wired to.  Each install
+describes its wiring in a json pin map file, e.g.
+
+	{
+		"chip": "/dev/gpiochip0",
+		"pins": {
+			"OpenerUp": {"line": 17, "direction": "output"},
+			"AtFloor":  {"line": 26, "direction": "input", "active_low": true, "bias": "pull-up"}
+		}
+	}
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// ControllerPins the pins the controller's RPi must have wired for a three relay opener,
+// other drives list their own pins
+var ControllerPins = []PiPin{OpenerUp, OpenerDown, OpenerStop}
+
+// LimitPins the controller's over-travel limit switches, at the top and bottom of the shaft
+var LimitPins = []PiPin{TopLimit, BottomLimit}
+
+// FloorPins the pins each floor's RPi must have wired, a call button for every floor
+func FloorPins(numFloors int) []PiPin {
+	return append(FloorRequestPins(numFloors), StopRequested, AtFloor)
+}
+
+// LineBias the pull up/down resistor setting for a line
+type LineBias int
+
+// LineBias constants are BiasAsIs (leave the chip's setting alone), BiasDisable, BiasPullUp and BiasPullDown.
+const (
+	BiasAsIs LineBias = iota
+	BiasDisable
+	BiasPullUp
+	BiasPullDown
+)
+
+var biasNames = [...]string{"as-is", "disable", "pull-up", "pull-down"}
+
+func (b LineBias) String() string {
+	return biasNames[b]
+}
+
+// MarshalText write the bias by name
+func (b LineBias) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText read the bias by name
+func (b *LineBias) UnmarshalText(text []byte) error {
+	for i, name := range biasNames {
+		if name == string(text) {
+			*b = LineBias(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bias %q, expected one of %s", text, strings.Join(biasNames[:], ", "))
+}
+
+// MarshalText write the direction by name
+func (d LineDirection) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText read the direction by name
+func (d *LineDirection) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case Input.String():
+		*d = Input
+	case Output.String():
+		*d = Output
+	default:
+		return fmt.Errorf("unknown direction %q, expected input or output", text)
+	}
+	return nil
+}
+
+// PinConfig the wiring of a single pin
+type PinConfig struct {
+	Line      int           `json:"line"`       // the BCM gpio line number
+	Direction LineDirection `json:"direction"`  // input or output
+	ActiveLow bool          `json:"active_low"` // the pin is on when the line is low
+	Bias      LineBias      `json:"bias"`       // pull up/down resistor
+	Initial   bool          `json:"initial"`    // the value an output is set to at startup
+}
+
+// lineConfig the config to request the pin's line with
+func (p PinConfig) lineConfig() LineConfig {
+	return LineConfig{Direction: p.Direction, ActiveLow: p.ActiveLow, Bias: p.Bias, Initial: p.Initial}
+}
+
+// PinMap the wiring of all of a RPi's pins
+type PinMap struct {
+	Chip string              `json:"chip"` // the gpio character device, defaults to DefaultChipPath
+	Pins map[PiPin]PinConfig `json:"pins"`
+}
+
+// DefaultPinMap the wiring used when an install does not provide a pin map file
+func DefaultPinMap() *PinMap {
+	output := func(line int) PinConfig { return PinConfig{Line: line, Direction: Output} }
+	input := func(line int) PinConfig { return PinConfig{Line: line, Direction: Input, Bias: BiasPullDown} }
+	return &PinMap{
+		Chip: DefaultChipPath,
+		Pins: map[PiPin]PinConfig{
+			OpenerUp:         output(17),
+			OpenerDown:       output(27),
+			OpenerStop:       output(22),
+			Floor1Requested:  input(5),
+			Floor2Requested:  input(6),
+			Floor3Requested:  input(13),
+			StopRequested:    input(19),
+			AtFloor:          input(26),
+			OpenerButton:     output(23),
+			MotorForward:     output(12),
+			MotorReverse:     output(16),
+			MotorEnable:      output(18),
+			ContactorUp:      output(20),
+			ContactorDown:    output(21),
+			StepperStep:      output(24),
+			StepperDirection: output(25),
+			StepperEnable:    {Line: 8, Direction: Output, ActiveLow: true}, // typical stepper drivers enable on low
+			StepperHome:      input(7),
+			// normally closed limit switches to ground, the pull up reads an open switch (or a
+			// broken wire) as tripped
+			TopLimit:    {Line: 9, Direction: Input, Bias: BiasPullUp},
+			BottomLimit: {Line: 10, Direction: Input, Bias: BiasPullUp},
+		},
+	}
+}
+
+// LoadPinMap read a pin map file, validating that the pins have been given distinct lines
+func LoadPinMap(path string) (*PinMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pin map: %w", err)
+	}
+	pinMap := &PinMap{}
+	if err := json.Unmarshal(data, pinMap); err != nil {
+		return nil, fmt.Errorf("parsing pin map %s: %w", path, err)
+	}
+	if pinMap.Chip == "" {
+		pinMap.Chip = DefaultChipPath
+	}
+	if err := pinMap.Validate(); err != nil {
+		return nil, fmt.Errorf("pin map %s: %w", path, err)
+	}
+	return pinMap, nil
+}
+
+// Only a copy of the pin map with just the given pins, so a RPi opens only the lines its
+// role uses and leaves the rest of a shared pin map's lines alone
+func (m *PinMap) Only(pins ...PiPin) *PinMap {
+	subset := &PinMap{Chip: m.Chip, Pins: map[PiPin]PinConfig{}}
+	for _, pin := range pins {
+		if config, ok := m.Pins[pin]; ok {
+			subset.Pins[pin] = config
+		}
+	}
+	return subset
+}
+
+// Validate check that no two pins share a line and that all of the required pins are mapped
+func (m *PinMap) Validate(required ...PiPin) error {
+	var problems []string
+
+	lineOwners := map[int][]string{}
+	for pin, config := range m.Pins {
+		if config.Line < 0 {
+			problems = append(problems, fmt.Sprintf("%s has invalid line %d", pin, config.Line))
+		}
+		lineOwners[config.Line] = append(lineOwners[config.Line], pin.String())
+	}
+	for line, owners := range lineOwners {
+		if len(owners) > 1 {
+			sort.Strings(owners)
+			problems = append(problems, fmt.Sprintf("line %d is mapped to more than one pin (%s)", line, strings.Join(owners, ", ")))
+		}
+	}
+	for _, pin := range required {
+		if _, ok := m.Pins[pin]; !ok {
+			problems = append(problems, fmt.Sprintf("required pin %s is not mapped", pin))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid pin map: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLoadPinMap a pin map file is read with pin names, directions and biases
+func TestLoadPinMap(t *testing.T) {
+	path := writePinMap(t, `{
+		"pins": {
+			"OpenerUp": {"line": 17, "direction": "output", "initial": false},
+			"AtFloor":  {"line": 26, "direction": "input", "active_low": true, "bias": "pull-up"}
+		}
+	}`)
+
+	pinMap, err := LoadPinMap(path)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultChipPath, pinMap.Chip)
+	assert.Equal(t, PinConfig{Line: 17, Direction: Output}, pinMap.Pins[OpenerUp])
+	assert.Equal(t, PinConfig{Line: 26, Direction: Input, ActiveLow: true, Bias: BiasPullUp}, pinMap.Pins[AtFloor])
+}
+
+// TestLoadPinMapDuplicateLine two pins on one line is rejected, naming both pins
+func TestLoadPinMapDuplicateLine(t *testing.T) {
+	path := writePinMap(t, `{"pins": {"OpenerUp": {"line": 17, "direction": "output"}, "OpenerDown": {"line": 17, "direction": "output"}}}`)
+
+	_, err := LoadPinMap(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 17 is mapped to more than one pin (OpenerDown, OpenerUp)")
+}
+
+// TestLoadPinMapBadNames unknown pins, directions and biases are rejected
+func TestLoadPinMapBadNames(t *testing.T) {
+	for _, contents := range []string{
+		`{"pins": {"OpenerSideways": {"line": 1}}}`,
+		`{"pins": {"OpenerUp": {"line": 1, "direction": "both"}}}`,
+		`{"pins": {"AtFloor": {"line": 1, "bias": "strong"}}}`,
+	} {
+		_, err := LoadPinMap(writePinMap(t, contents))
+		assert.Error(t, err, contents)
+	}
+}
+
+// TestValidateRequiredPins a missing required pin is reported
+func TestValidateRequiredPins(t *testing.T) {
+	pinMap := DefaultPinMap()
+	assert.NoError(t, pinMap.Validate(ControllerPins...))
+	assert.NoError(t, pinMap.Validate(FloorPins(3)...))
+
+	delete(pinMap.Pins, OpenerStop)
+	err := pinMap.Validate(ControllerPins...)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "required pin OpenerStop is not mapped")
+}
+
+// TestPinMapOnly a role's pin map keeps just its own pins
+func TestPinMapOnly(t *testing.T) {
+	pinMap := DefaultPinMap()
+	floorMap := pinMap.Only(FloorPins(3)...)
+	assert.Equal(t, pinMap.Chip, floorMap.Chip)
+	assert.Len(t, floorMap.Pins, 5)
+	assert.NoError(t, floorMap.Validate(FloorPins(3)...))
+	for _, pin := range append(ControllerPins, MotorForward, ContactorUp, StepperStep) {
+		_, ok := floorMap.Pins[pin]
+		assert.False(t, ok, pin.String())
+	}
+	assert.Len(t, pinMap.Pins, 20, "the full map is left alone")
+
+	// pins the map doesn't have are left for Validate to report
+	assert.Len(t, pinMap.Only(FloorPins(4)...).Pins, 5)
+}
+
+// TestValidateFloorCount every floor's call button must be mapped
+func TestValidateFloorCount(t *testing.T) {
+	err := DefaultPinMap().Validate(FloorPins(4)...)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "required pin Floor4Requested is not mapped")
+
+	pinMap, err := LoadPinMap(writePinMap(t, `{"pins": {"Floor1Requested": {"line": 5}, "Floor2Requested": {"line": 6},
+		"Floor3Requested": {"line": 13}, "Floor4Requested": {"line": 4}, "StopRequested": {"line": 19}, "AtFloor": {"line": 26}}}`))
+	assert.NoError(t, err)
+	assert.NoError(t, pinMap.Validate(FloorPins(4)...))
+	assert.Equal(t, 4, pinMap.Pins[FloorRequested(4)].Line)
+}
+
+func writePinMap(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "pinmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "pins.json")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,261 @@
+package common
+
+/*
+pulse.go implements a RPi decorator that turns signals sent to the garage door opener's
+relays into momentary button presses
+*/
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultOpenerPulseConfig a press long enough for typical garage door opener wall buttons
+var DefaultOpenerPulseConfig = PulseConfig{
+	PressDuration: 300 * time.Millisecond,
+	MinGap:        500 * time.Millisecond,
+	Pins:          []PiPin{OpenerUp, OpenerDown, OpenerStop, OpenerButton},
+}
+
+// releaseAttempts how many times turning a relay back off is tried before giving up
+const releaseAttempts = 3
+
+// ErrPulseIncomplete matches (with errors.Is) every PulseError
+var ErrPulseIncomplete = errors.New("pulse did not complete")
+
+// ErrPreempted the PulseError cause of a pulse that was cut short, or abandoned, for a
+// preempting pulse
+var ErrPreempted = errors.New("preempted by another pulse")
+
+// PulseError a pulse that could not be completed
+type PulseError struct {
+	Pin   PiPin
+	Stage string // the step of the pulse that failed: gap, interlock, press or release
+	Err   error
+}
+
+func (e *PulseError) Error() string {
+	return fmt.Sprintf("%s pulse failed at %s: %v", e.Pin, e.Stage, e.Err)
+}
+
+// Unwrap get the underlying device error
+func (e *PulseError) Unwrap() error {
+	return e.Err
+}
+
+// Is all pulse errors are ErrPulseIncomplete
+func (e *PulseError) Is(target error) bool {
+	return target == ErrPulseIncomplete
+}
+
+// PulseConfig the shape of a relay button press
+type PulseConfig struct {
+	PressDuration time.Duration // how long the relay is held on
+	MinGap        time.Duration // how long after a pulse ends before the next may start
+	Pins          []PiPin       // the pulsed pins, at most one of them is ever on
+}
+
+// PulsedRPi wraps a LevelRPi, sending signals on the configured pins as a single press of
+// PressDuration.  Pulses are serialized and spaced by MinGap, and all of the pulsed pins are
+// checked to be off before one is turned on, so two relays are never energized together.
+// A preempting pulse goes ahead of the others without waiting out the gap.
+type PulsedRPi struct {
+	device     LevelRPi
+	clock      Clock
+	config     PulseConfig
+	lastPulse  time.Time     // when the last pulse ended
+	preempt    chan struct{} // signalled to cut short the pulse in progress
+	preempting int           // the preempting pulses waiting for their turn
+	preemptMu  sync.Mutex
+	pulseMu    sync.Mutex
+	failures   int
+	failureMu  sync.Mutex
+}
+
+// NewPulsedRPi wrap device, pulsing config's pins
+func NewPulsedRPi(device LevelRPi, config PulseConfig) *PulsedRPi {
+	return &PulsedRPi{device: device, clock: RealClock, config: config, preempt: make(chan struct{}, 1)}
+}
+
+// SendSignal press the pin's relay, returning once it has been released.  A pulse that could
+// not be completed is reported with a *PulseError.
+func (p *PulsedRPi) SendSignal(pin PiPin) error {
+	if !p.isPulsed(pin) {
+		return p.device.SendSignal(pin)
+	}
+	return p.countFailure(p.pulse(pin, false))
+}
+
+// Preempt press the pin's relay now, for an emergency: the press in progress is released at
+// once, the presses waiting for their turn are abandoned and the gap isn't waited out
+func (p *PulsedRPi) Preempt(pin PiPin) error {
+	if !p.isPulsed(pin) {
+		return p.device.SendSignal(pin)
+	}
+	p.setPreempting(1)
+	defer p.setPreempting(-1)
+	select {
+	case p.preempt <- struct{}{}:
+	default: // already signalled
+	}
+	return p.countFailure(p.pulse(pin, true))
+}
+
+// SetSignal pass the signal through to the wrapped device
+func (p *PulsedRPi) SetSignal(pin PiPin, value bool) error {
+	return p.device.SetSignal(pin, value)
+}
+
+// GetSignal pass the request through to the wrapped device
+func (p *PulsedRPi) GetSignal(pin PiPin) (bool, error) {
+	return p.device.GetSignal(pin)
+}
+
+// WatchSignal pass the request through to the wrapped device
+func (p *PulsedRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	return p.device.WatchSignal(pin)
+}
+
+// FailedPulses the number of pulses that could not be completed
+func (p *PulsedRPi) FailedPulses() int {
+	p.failureMu.Lock()
+	defer p.failureMu.Unlock()
+	return p.failures
+}
+
+// countFailure count and log a pulse that could not be completed, pulses cut short for a
+// preempting pulse aren't failures
+func (p *PulsedRPi) countFailure(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrPreempted) {
+		log.Warnf("%v", err)
+		return err
+	}
+	p.failureMu.Lock()
+	p.failures++
+	p.failureMu.Unlock()
+	log.Errorf("%v", err)
+	return err
+}
+
+// pulse turn pin on for the press duration, a preempting pulse can't be cut short and
+// doesn't wait out the gap
+func (p *PulsedRPi) pulse(pin PiPin, preempting bool) error {
+	p.pulseMu.Lock()
+	defer p.pulseMu.Unlock()
+
+	if preempting {
+		select {
+		case <-p.preempt: // nothing left to cut short
+		default:
+		}
+	} else if p.isPreempted() {
+		return &PulseError{Pin: pin, Stage: "gap", Err: ErrPreempted}
+	} else if wait := p.lastPulse.Add(p.config.MinGap).Sub(p.clock.Now()); wait > 0 {
+		log.Debugf("waiting %s before pulsing %s", wait, pin)
+		if !p.wait(wait) {
+			return &PulseError{Pin: pin, Stage: "gap", Err: ErrPreempted}
+		}
+	}
+	defer func() { p.lastPulse = p.clock.Now() }()
+
+	if err := p.checkInterlock(pin); err != nil {
+		return &PulseError{Pin: pin, Stage: "interlock", Err: err}
+	}
+	if err := p.device.SetSignal(pin, true); err != nil {
+		p.release(pin) // the line may have changed before the error
+		return &PulseError{Pin: pin, Stage: "press", Err: err}
+	}
+	held := true
+	if preempting {
+		p.clock.Sleep(p.config.PressDuration)
+	} else {
+		held = p.wait(p.config.PressDuration)
+	}
+	if err := p.release(pin); err != nil {
+		return &PulseError{Pin: pin, Stage: "release", Err: err}
+	}
+	if !held {
+		return &PulseError{Pin: pin, Stage: "press", Err: ErrPreempted}
+	}
+	return nil
+}
+
+// wait wait for d, false when a preempting pulse cut the wait short
+func (p *PulsedRPi) wait(d time.Duration) bool {
+	select {
+	case <-p.clock.After(d):
+		return true
+	case <-p.preempt:
+		return false
+	}
+}
+
+// setPreempting count a preempting pulse in (1) or out (-1)
+func (p *PulsedRPi) setPreempting(delta int) {
+	p.preemptMu.Lock()
+	defer p.preemptMu.Unlock()
+	p.preempting += delta
+}
+
+// isPreempted whether a preempting pulse is waiting for its turn
+func (p *PulsedRPi) isPreempted() bool {
+	p.preemptMu.Lock()
+	defer p.preemptMu.Unlock()
+	return p.preempting > 0
+}
+
+// checkInterlock make sure none of the other pulsed pins is on, turning off any that are
+func (p *PulsedRPi) checkInterlock(pin PiPin) error {
+	for _, other := range p.config.Pins {
+		if other == pin {
+			continue
+		}
+		on, err := p.device.GetSignal(other)
+		if err != nil {
+			return fmt.Errorf("can't read %s: %w", other, err)
+		}
+		if !on {
+			continue
+		}
+		log.Warnf("%s was left on, turning it off before pulsing %s", other, pin)
+		if err := p.release(other); err != nil {
+			return fmt.Errorf("%s is stuck on: %w", other, err)
+		}
+	}
+	return nil
+}
+
+// release turn pin off, retrying since a relay left on keeps the opener's button held
+func (p *PulsedRPi) release(pin PiPin) error {
+	var err error
+	for attempt := 0; attempt < releaseAttempts; attempt++ {
+		if err = p.device.SetSignal(pin, false); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (p *PulsedRPi) isPulsed(pin PiPin) bool {
+	for _, pulsed := range p.config.Pins {
+		if pulsed == pin {
+			return true
+		}
+	}
+	return false
+}
+
+// PulsedRPi constructor setters for builder pattern
+
+// SetClock used by testing to control time
+func (p *PulsedRPi) SetClock(clock Clock) *PulsedRPi {
+	p.clock = clock
+	return p
+}
@@ -0,0 +1,171 @@
+package common
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPulseConfig = PulseConfig{PressDuration: 300 * time.Millisecond, MinGap: 500 * time.Millisecond, Pins: ControllerPins}
+
+type levelChange struct {
+	at    time.Duration // since the start of the test
+	pin   PiPin
+	value bool
+}
+
+// recordingRelays records the level changes of its outputs, failing the ones the test chooses
+type recordingRelays struct {
+	clock   *FakeClock
+	start   time.Time
+	values  map[PiPin]bool
+	changes []levelChange
+	failOn  map[PiPin]bool // SetSignal to this value fails
+	mu      sync.Mutex
+}
+
+func newRecordingRelays(clock *FakeClock) *recordingRelays {
+	return &recordingRelays{clock: clock, start: clock.Now(), values: map[PiPin]bool{}, failOn: map[PiPin]bool{}}
+}
+
+func (r *recordingRelays) SendSignal(pin PiPin) error {
+	return r.SetSignal(pin, true)
+}
+
+func (r *recordingRelays) SetSignal(pin PiPin, value bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if fail, ok := r.failOn[pin]; ok && fail == value {
+		return errors.New("relay driver fault")
+	}
+	r.values[pin] = value
+	r.changes = append(r.changes, levelChange{at: r.clock.Now().Sub(r.start), pin: pin, value: value})
+	return nil
+}
+
+func (r *recordingRelays) GetSignal(pin PiPin) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[pin], nil
+}
+
+func (r *recordingRelays) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	return nil, ErrWatchNotSupported
+}
+
+// TestPulse a signal is a press of the configured duration
+func TestPulse(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	done := sendAsync(pulsed, OpenerUp)
+	clock.BlockUntil(1)
+	clock.Advance(300 * time.Millisecond)
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, []levelChange{{0, OpenerUp, true}, {300 * time.Millisecond, OpenerUp, false}}, relays.changes)
+}
+
+// TestPulseMinGap back to back pulses are spaced by the minimum gap
+func TestPulseMinGap(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	done := sendAsync(pulsed, OpenerStop)
+	clock.BlockUntil(1)
+	clock.Advance(300 * time.Millisecond)
+	assert.NoError(t, <-done)
+
+	done = sendAsync(pulsed, OpenerDown)
+	clock.BlockUntil(1) // waiting out the gap
+	clock.Advance(500 * time.Millisecond)
+	clock.BlockUntil(1) // pressing
+	clock.Advance(300 * time.Millisecond)
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, levelChange{800 * time.Millisecond, OpenerDown, true}, relays.changes[2])
+}
+
+// TestPulseInterlock a relay left on is turned off before another is pressed
+func TestPulseInterlock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	relays.values[OpenerDown] = true
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	done := sendAsync(pulsed, OpenerUp)
+	clock.BlockUntil(1)
+	clock.Advance(300 * time.Millisecond)
+
+	assert.NoError(t, <-done)
+	assert.Equal(t, levelChange{0, OpenerDown, false}, relays.changes[0])
+	assert.Equal(t, levelChange{0, OpenerUp, true}, relays.changes[1])
+}
+
+// TestPulseInterlockStuck a pulse is refused when another relay can't be turned off
+func TestPulseInterlockStuck(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	relays.values[OpenerDown] = true
+	relays.failOn[OpenerDown] = false
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	err := pulsed.SendSignal(OpenerUp)
+	assert.True(t, errors.Is(err, ErrPulseIncomplete))
+	assert.False(t, relays.values[OpenerUp], "opener up must not be energized with opener down")
+	assert.Equal(t, 1, pulsed.FailedPulses())
+}
+
+// TestPulseReleaseFailure a relay that can't be released is reported
+func TestPulseReleaseFailure(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	relays.failOn[OpenerStop] = false
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	done := sendAsync(pulsed, OpenerStop)
+	clock.BlockUntil(1)
+	clock.Advance(300 * time.Millisecond)
+
+	err := <-done
+	var pulseErr *PulseError
+	assert.True(t, errors.As(err, &pulseErr))
+	assert.Equal(t, OpenerStop, pulseErr.Pin)
+	assert.Equal(t, "release", pulseErr.Stage)
+	assert.Equal(t, 1, pulsed.FailedPulses())
+}
+
+// TestPulsePreempt a preempting pulse cuts short the press in progress and doesn't wait out
+// the gap
+func TestPulsePreempt(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	relays := newRecordingRelays(clock)
+	pulsed := NewPulsedRPi(relays, testPulseConfig).SetClock(clock)
+
+	up := sendAsync(pulsed, OpenerUp)
+	clock.BlockUntil(1)
+	clock.Advance(100 * time.Millisecond)
+	stop := make(chan error, 1)
+	go func() { stop <- pulsed.Preempt(OpenerStop) }()
+	err := <-up
+	assert.True(t, errors.Is(err, ErrPreempted))
+	assert.True(t, errors.Is(err, ErrPulseIncomplete))
+	clock.BlockUntil(2) // the abandoned press's wait, and the stop's press
+	clock.Advance(300 * time.Millisecond)
+
+	assert.NoError(t, <-stop)
+	assert.Equal(t, []levelChange{{0, OpenerUp, true}, {100 * time.Millisecond, OpenerUp, false},
+		{100 * time.Millisecond, OpenerStop, true}, {400 * time.Millisecond, OpenerStop, false}}, relays.changes)
+	assert.Equal(t, 0, pulsed.FailedPulses())
+}
+
+func sendAsync(rpi RPi, pin PiPin) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- rpi.SendSignal(pin) }()
+	return done
+}
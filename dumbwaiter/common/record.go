@@ -0,0 +1,181 @@
+package common
+
+/*
+record.go implements a RPi decorator that records the gpio traffic of a device, one json
+record per line, so what happened on a real install can be replayed with ReplayRPi
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RecordOp the RPi call (or watched event) a record was made for
+type RecordOp string
+
+// RecordOp constants are the RPi calls and the edges seen by watchers
+const (
+	OpSend    RecordOp = "send"
+	OpPreempt RecordOp = "preempt"
+	OpSet     RecordOp = "set"
+	OpGet     RecordOp = "get"
+	OpWatch   RecordOp = "watch"
+	OpEvent   RecordOp = "event"
+)
+
+// Record a single line of a recording
+type Record struct {
+	Time  time.Time `json:"time"`
+	Op    RecordOp  `json:"op"`
+	Pin   PiPin     `json:"pin"`
+	Value bool      `json:"value,omitempty"` // the value read, set or the watched edge (true is rising)
+	Err   string    `json:"error,omitempty"`
+}
+
+// RecordingRPi wraps a RPi, writing every call's pin, value and error, and every watched
+// event, to a recording
+type RecordingRPi struct {
+	device  RPi
+	enc     *json.Encoder
+	clock   Clock
+	failed  bool // a record could not be written, only the first failure is logged
+	writeMu sync.Mutex
+}
+
+// NewRecordingRPi wrap device, the records are written to w
+func NewRecordingRPi(device RPi, w io.Writer) *RecordingRPi {
+	return &RecordingRPi{device: device, enc: json.NewEncoder(w), clock: RealClock}
+}
+
+// SendSignal send the signal to the wrapped device and record it
+func (r *RecordingRPi) SendSignal(pin PiPin) error {
+	err := r.device.SendSignal(pin)
+	r.record(OpSend, pin, true, err)
+	return err
+}
+
+// Preempt preempt the signal on the wrapped device and record it, a device that can't
+// preempt sends the signal and it is recorded as a send
+func (r *RecordingRPi) Preempt(pin PiPin) error {
+	preempter, ok := r.device.(Preempter)
+	if !ok {
+		return r.SendSignal(pin)
+	}
+	err := preempter.Preempt(pin)
+	r.record(OpPreempt, pin, true, err)
+	return err
+}
+
+// SetSignal set the signal on the wrapped device (which must be a LevelRPi) and record it
+func (r *RecordingRPi) SetSignal(pin PiPin, value bool) error {
+	var err error
+	if device, ok := r.device.(LevelRPi); ok {
+		err = device.SetSignal(pin, value)
+	} else {
+		err = fmt.Errorf("%s can't be set to a level on this device", pin)
+	}
+	r.record(OpSet, pin, value, err)
+	return err
+}
+
+// GetSignal read the signal from the wrapped device and record it
+func (r *RecordingRPi) GetSignal(pin PiPin) (bool, error) {
+	value, err := r.device.GetSignal(pin)
+	r.record(OpGet, pin, value, err)
+	return value, err
+}
+
+// WatchSignal watch the signal on the wrapped device, recording the watch and each event
+func (r *RecordingRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	events, err := r.device.WatchSignal(pin)
+	r.record(OpWatch, pin, false, err)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(chan SignalEvent, watchBufferSize)
+	go func() {
+		defer close(recorded)
+		for event := range events {
+			r.recordAt(event.Time, OpEvent, event.Pin, event.Value(), nil)
+			recorded <- event
+		}
+	}()
+	return recorded, nil
+}
+
+func (r *RecordingRPi) record(op RecordOp, pin PiPin, value bool, err error) {
+	r.recordAt(r.clock.Now(), op, pin, value, err)
+}
+
+func (r *RecordingRPi) recordAt(at time.Time, op RecordOp, pin PiPin, value bool, err error) {
+	rec := Record{Time: at, Op: op, Pin: pin, Value: value}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if writeErr := r.enc.Encode(rec); writeErr != nil && !r.failed {
+		log.Errorf("recording gpio traffic failed, later records will be lost: %v", writeErr)
+		r.failed = true
+	}
+}
+
+// RecordingRPi constructor setters for builder pattern
+
+// SetClock used by testing to control the record times
+func (r *RecordingRPi) SetClock(clock Clock) *RecordingRPi {
+	r.clock = clock
+	return r
+}
+
+// LoadRecording read a recording file
+func LoadRecording(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording: %w", err)
+	}
+	defer f.Close()
+	records, err := ReadRecording(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading recording %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// ReadRecording read the records, one per line
+func ReadRecording(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// recordError turn a recorded error back into an error, ErrWatchNotSupported is matched so
+// a replayed floor falls back to polling like the recorded one did
+func recordError(rec Record) error {
+	if rec.Err == "" {
+		return nil
+	}
+	if strings.Contains(rec.Err, ErrWatchNotSupported.Error()) {
+		return ErrWatchNotSupported
+	}
+	return errors.New(rec.Err)
+}
@@ -0,0 +1,154 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var recordStart = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// TestRecordingRPi every call is recorded with its pin, value and error, and the records read
+// back as they were written
+func TestRecordingRPi(t *testing.T) {
+	chip := NewFakeChip(32)
+	clock := NewFakeClock(recordStart)
+	var buf bytes.Buffer
+	recorder := NewRecordingRPi(NewRPiDevice(DefaultPinMap()).SetChip(chip), &buf).SetClock(clock)
+
+	assert.NoError(t, recorder.SendSignal(OpenerUp))
+	clock.Advance(time.Second)
+	chip.SetInput(lineOf(AtFloor), true)
+	value, err := recorder.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.True(t, value)
+	assert.Error(t, recorder.SendSignal(StopRequested))
+
+	records, err := ReadRecording(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, Record{Time: recordStart, Op: OpSend, Pin: OpenerUp, Value: true}, records[0])
+	assert.Equal(t, Record{Time: recordStart.Add(time.Second), Op: OpGet, Pin: AtFloor, Value: true}, records[1])
+	assert.Equal(t, OpSend, records[2].Op)
+	assert.Equal(t, StopRequested, records[2].Pin)
+	assert.NotEmpty(t, records[2].Err)
+}
+
+// preemptingRPi a RPi that can preempt, its preempts are counted
+type preemptingRPi struct {
+	RPi
+	preempts int
+}
+
+func (p *preemptingRPi) Preempt(pin PiPin) error {
+	p.preempts++
+	return p.SendSignal(pin)
+}
+
+// TestRecordingPreempt a preempt is recorded as its own op, a device that can't preempt
+// records the send it made instead
+func TestRecordingPreempt(t *testing.T) {
+	var buf bytes.Buffer
+	device := &preemptingRPi{RPi: NewRPiDevice(DefaultPinMap()).SetChip(NewFakeChip(32))}
+	assert.NoError(t, NewRecordingRPi(device, &buf).Preempt(OpenerStop))
+	assert.Equal(t, 1, device.preempts)
+	assert.NoError(t, NewRecordingRPi(device.RPi, &buf).Preempt(OpenerStop))
+
+	records, err := ReadRecording(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, OpPreempt, records[0].Op)
+	assert.Equal(t, OpenerStop, records[0].Pin)
+	assert.Equal(t, OpSend, records[1].Op)
+}
+
+// TestRecordingWatchedEvents the watch and each event it delivers are recorded
+func TestRecordingWatchedEvents(t *testing.T) {
+	chip := NewFakeChip(32)
+	var buf bytes.Buffer
+	recorder := NewRecordingRPi(NewRPiDevice(DefaultPinMap()).SetChip(chip), &buf)
+
+	events, err := recorder.WatchSignal(AtFloor)
+	assert.NoError(t, err)
+	chip.SetInput(lineOf(AtFloor), true)
+	event := <-events
+	assert.Equal(t, Rising, event.Edge)
+
+	records, err := ReadRecording(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, OpWatch, records[0].Op)
+	assert.Equal(t, OpEvent, records[1].Op)
+	assert.Equal(t, AtFloor, records[1].Pin)
+	assert.True(t, records[1].Value)
+	assert.True(t, event.Time.Equal(records[1].Time), "events are recorded at the time they were seen")
+}
+
+// TestReplayGetSignal reads follow the recording as the replay's clock advances
+func TestReplayGetSignal(t *testing.T) {
+	records := []Record{
+		{Time: recordStart, Op: OpGet, Pin: AtFloor},
+		{Time: recordStart.Add(10 * time.Second), Op: OpGet, Pin: AtFloor, Value: true},
+		{Time: recordStart.Add(20 * time.Second), Op: OpGet, Pin: AtFloor, Err: "line busy"},
+	}
+	clock := NewFakeClock(time.Now())
+	replay := NewReplayRPi(records, 10).SetClock(clock)
+
+	value, err := replay.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.False(t, value)
+	clock.Advance(time.Second) // ten recorded seconds
+	value, err = replay.GetSignal(AtFloor)
+	assert.NoError(t, err)
+	assert.True(t, value)
+	clock.Advance(time.Second)
+	_, err = replay.GetSignal(AtFloor)
+	assert.EqualError(t, err, "line busy")
+	value, _ = replay.GetSignal(Floor1Requested)
+	assert.False(t, value, "a pin missing from the recording reads off")
+}
+
+// TestReplayWatchSignal watchers get the recorded events at the replay's pace, and the
+// recorded watch errors
+func TestReplayWatchSignal(t *testing.T) {
+	records := []Record{
+		{Time: recordStart, Op: OpWatch, Pin: AtFloor},
+		{Time: recordStart, Op: OpWatch, Pin: StopRequested, Err: "floor1: " + ErrWatchNotSupported.Error()},
+		{Time: recordStart.Add(4 * time.Second), Op: OpEvent, Pin: AtFloor, Value: true},
+		{Time: recordStart.Add(6 * time.Second), Op: OpEvent, Pin: AtFloor},
+	}
+	clock := NewFakeClock(time.Now())
+	replay := NewReplayRPi(records, 2).SetClock(clock)
+
+	_, err := replay.WatchSignal(StopRequested)
+	assert.Equal(t, ErrWatchNotSupported, err)
+	events, err := replay.WatchSignal(AtFloor)
+	assert.NoError(t, err)
+
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, Rising, (<-events).Edge)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Equal(t, Falling, (<-events).Edge)
+	<-replay.Done()
+	_, open := <-events
+	assert.False(t, open, "watchers are closed at the end of the recording")
+}
+
+// TestReplaySent the signals sent to the replay can be compared with the recorded ones
+func TestReplaySent(t *testing.T) {
+	records := []Record{
+		{Time: recordStart, Op: OpSend, Pin: OpenerUp, Value: true},
+		{Time: recordStart.Add(time.Second), Op: OpGet, Pin: AtFloor, Value: true},
+		{Time: recordStart.Add(2 * time.Second), Op: OpSend, Pin: OpenerStop, Value: true},
+		{Time: recordStart.Add(3 * time.Second), Op: OpPreempt, Pin: OpenerStop, Value: true},
+	}
+	replay := NewReplayRPi(records, 1).SetClock(NewFakeClock(time.Now()))
+	assert.NoError(t, replay.SendSignal(OpenerUp))
+	assert.NoError(t, replay.SendSignal(OpenerStop))
+	assert.NoError(t, replay.Preempt(OpenerStop))
+	assert.Equal(t, replay.RecordedSends(), replay.Sent())
+}
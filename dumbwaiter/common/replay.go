@@ -0,0 +1,196 @@
+package common
+
+/*
+replay.go implements a RPi that plays back a recording made by RecordingRPi, at the
+recorded speed or faster, so a floor or the controller can be fed an install's gpio traffic
+*/
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ReplayRPi plays back a recording.  Reads return the pin's last recorded value (from a read
+// or a watched event) at the replay's current point in the recording, watchers get the
+// recorded events as the replay reaches them, and sent signals are kept so they can be
+// compared with the recorded ones.  The replay's clock starts with the first call.
+type ReplayRPi struct {
+	records  []Record
+	speed    float64 // how many times faster than recorded the replay runs
+	clock    Clock
+	started  time.Time
+	running  bool
+	watchers map[PiPin][]chan SignalEvent
+	done     chan struct{}
+	sent     []Record
+	mu       sync.Mutex
+}
+
+// NewReplayRPi create a replay of records at speed times the recorded speed (1 is real time)
+func NewReplayRPi(records []Record, speed float64) *ReplayRPi {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &ReplayRPi{
+		records:  records,
+		speed:    speed,
+		clock:    RealClock,
+		watchers: map[PiPin][]chan SignalEvent{},
+		done:     make(chan struct{}),
+	}
+}
+
+// SendSignal keep the signal for Sent
+func (r *ReplayRPi) SendSignal(pin PiPin) error {
+	return r.keepSent(Record{Op: OpSend, Pin: pin, Value: true})
+}
+
+// Preempt keep the preempting signal for Sent
+func (r *ReplayRPi) Preempt(pin PiPin) error {
+	return r.keepSent(Record{Op: OpPreempt, Pin: pin, Value: true})
+}
+
+// SetSignal keep the signal for Sent
+func (r *ReplayRPi) SetSignal(pin PiPin, value bool) error {
+	return r.keepSent(Record{Op: OpSet, Pin: pin, Value: value})
+}
+
+// GetSignal the pin's last recorded value (and error) at the replay's point in the recording
+func (r *ReplayRPi) GetSignal(pin PiPin) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startLocked()
+	now := r.recordingTimeLocked()
+	var value bool
+	var err error
+	for _, rec := range r.records {
+		if rec.Time.After(now) {
+			break
+		}
+		if rec.Pin == pin && (rec.Op == OpGet || rec.Op == OpEvent) {
+			value, err = rec.Value, recordError(rec)
+		}
+	}
+	return value, err
+}
+
+// WatchSignal get the pin's recorded events as the replay reaches them, or the error the
+// recorded watch got
+func (r *ReplayRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range r.records {
+		if rec.Op == OpWatch && rec.Pin == pin {
+			if err := recordError(rec); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	watcher := make(chan SignalEvent, watchBufferSize)
+	r.watchers[pin] = append(r.watchers[pin], watcher)
+	r.startLocked()
+	return watcher, nil
+}
+
+// Sent the signals sent, preempted or set on the replay, in order
+func (r *ReplayRPi) Sent() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.sent...)
+}
+
+// RecordedSends the signals sent, preempted or set in the recording, in order
+func (r *ReplayRPi) RecordedSends() []Record {
+	var sends []Record
+	for _, rec := range r.records {
+		if rec.Op == OpSend || rec.Op == OpPreempt || rec.Op == OpSet {
+			sends = append(sends, Record{Op: rec.Op, Pin: rec.Pin, Value: rec.Value})
+		}
+	}
+	return sends
+}
+
+// Done closed when the replay has played all of its events
+func (r *ReplayRPi) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *ReplayRPi) keepSent(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startLocked()
+	r.sent = append(r.sent, rec)
+	return nil
+}
+
+// startLocked start the replay's clock and event playback on the first call, the caller
+// must hold mu
+func (r *ReplayRPi) startLocked() {
+	if r.running {
+		return
+	}
+	r.running = true
+	r.started = r.clock.Now()
+	go r.playEvents()
+}
+
+// recordingTimeLocked the replay's current point in the recording, the caller must hold mu
+func (r *ReplayRPi) recordingTimeLocked() time.Time {
+	if len(r.records) == 0 {
+		return time.Time{}
+	}
+	elapsed := r.clock.Now().Sub(r.started)
+	return r.records[0].Time.Add(time.Duration(float64(elapsed) * r.speed))
+}
+
+// playEvents send each recorded event to the pin's watchers when the replay reaches it, the
+// watchers are closed at the end of the recording
+func (r *ReplayRPi) playEvents() {
+	defer func() {
+		r.mu.Lock()
+		for _, watchers := range r.watchers {
+			for _, watcher := range watchers {
+				close(watcher)
+			}
+		}
+		r.watchers = map[PiPin][]chan SignalEvent{}
+		r.mu.Unlock()
+		close(r.done)
+	}()
+	for _, rec := range r.records {
+		r.mu.Lock()
+		wait := time.Duration(float64(rec.Time.Sub(r.recordingTimeLocked())) / r.speed)
+		r.mu.Unlock()
+		if wait > 0 {
+			<-r.clock.After(wait)
+		}
+		if rec.Op != OpEvent {
+			continue
+		}
+		edge := Falling
+		if rec.Value {
+			edge = Rising
+		}
+		event := SignalEvent{Pin: rec.Pin, Edge: edge, Time: r.clock.Now()}
+		r.mu.Lock()
+		for _, watcher := range r.watchers[rec.Pin] {
+			select {
+			case watcher <- event:
+			default:
+				log.Warnf("replayed %s watcher is not keeping up, dropped %s event", rec.Pin, edge)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// ReplayRPi constructor setters for builder pattern
+
+// SetClock used by testing to control the replay's pace
+func (r *ReplayRPi) SetClock(clock Clock) *ReplayRPi {
+	r.clock = clock
+	return r
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,6 +19,8 @@ type MockRPi struct {
 	latestPin   PiPin
 	latestValue bool
 	pinMu       sync.RWMutex
+
+	watchers map[PiPin][]chan SignalEvent
 }
 
 // NewMockRPi create a mock RPi object that validates it gets send signal calls as in expectedCalls
@@ -36,12 +39,39 @@ func (m *MockRPi) SendSignal(pin PiPin) error {
 
 	m.pinMu.Lock()
 	defer m.pinMu.Unlock()
+	// the signal moves to the new pin, synthesize the edges a watcher would see
+	if m.latestValue && m.latestPin != pin {
+		m.emit(m.latestPin, Falling)
+	}
+	if !m.latestValue || m.latestPin != pin {
+		m.emit(pin, Rising)
+	}
 	m.latestPin = pin
 	m.latestValue = true
 
 	return nil
 }
 
+// WatchSignal mock watch signal, events are synthesized from the SendSignal calls
+func (m *MockRPi) WatchSignal(pin PiPin) (<-chan SignalEvent, error) {
+	m.pinMu.Lock()
+	defer m.pinMu.Unlock()
+	if m.watchers == nil {
+		m.watchers = map[PiPin][]chan SignalEvent{}
+	}
+	watcher := make(chan SignalEvent, watchBufferSize)
+	m.watchers[pin] = append(m.watchers[pin], watcher)
+	return watcher, nil
+}
+
+// emit send an event to the pin's watchers, the caller must hold pinMu
+func (m *MockRPi) emit(pin PiPin, edge Edge) {
+	event := SignalEvent{Pin: pin, Edge: edge, Time: time.Now()}
+	for _, watcher := range m.watchers[pin] {
+		watcher <- event
+	}
+}
+
 // GetSignal mock get signal with noop
 func (m *MockRPi) GetSignal(pin PiPin) (bool, error) {
 	m.pinMu.RLock()
@@ -1,26 +1,252 @@
 package cli
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
+)
+
+// DefaultClientConfig short timeouts and a few quick retries, a floor's call should reach the
+// controller within a couple of seconds or be reported as failed
+var DefaultClientConfig = ClientConfig{
+	Timeout: 2 * time.Second,
+	Retries: 3,
+	Backoff: 100 * time.Millisecond,
+}
+
+// ClientConfig how the client calls the controller
+type ClientConfig struct {
+	Timeout time.Duration // how long each attempt has to complete
+	Retries int           // how many times a request that failed transiently is retried
+	Backoff time.Duration // the wait before the first retry, doubled for each retry after
+}
+
+// StatusError the controller rejected a request
+type StatusError struct {
+	StatusCode int
+	Code       string // the controller's error code, see api.ErrorResponse
+	Message    string // the controller's error message
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("controller returned %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Unwrap get the controller error the error code stands for, so it matches with errors.Is
+func (e *StatusError) Unwrap() error {
+	switch e.Code {
+	case api.InvalidFloorCode:
+		return api.ErrInvalidFloor
+	case api.ControllerFaultCode:
+		return api.ErrControllerFault
+	case api.NoCallCode:
+		return api.ErrNoCall
+	case api.IllegalStateCode:
+		return api.ErrIllegalTransition
+	case api.PositionUnknownCode:
+		return api.ErrPositionUnknown
+	case api.EmergencyStopCode:
+		return api.ErrEmergencyStopped
+	}
+	return nil
+}
+
+// UnreachableError the controller could not be reached, after retrying
+type UnreachableError struct {
+	Err error // the last attempt's error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%v: %v", api.ErrControllerUnreachable, e.Err)
+}
+
+// Unwrap get the last attempt's error
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// Is all unreachable errors are api.ErrControllerUnreachable
+func (e *UnreachableError) Is(target error) bool {
+	return target == api.ErrControllerUnreachable
+}
+
+// transient whether the request might work if it is tried again right away.  A controller
+// still homing the car answers 503 too, but homing takes longer than the retries, the caller
+// has to wait for it.
+func (e *StatusError) transient() bool {
+	if e.Code == api.PositionUnknownCode {
+		return false
+	}
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
 // ControllerHTTPClient controller client implementing http calls to the controller
 type ControllerHTTPClient struct {
-	addr string // the url (with port to use when communicating with the controller)
+	addr   string // the url (with port to use when communicating with the controller)
+	config ClientConfig
+	client *http.Client
+	clock  common.Clock
 }
 
 // NewControllerHTTPClient instantiate an http client for communicating with the controller
 func NewControllerHTTPClient(addr string) *ControllerHTTPClient {
-	return &ControllerHTTPClient{addr: addr}
+	return &ControllerHTTPClient{
+		addr:   addr,
+		config: DefaultClientConfig,
+		client: &http.Client{Timeout: DefaultClientConfig.Timeout},
+		clock:  common.RealClock,
+	}
 }
 
-// SetRequestedFloor send a floor request to the controller
-func (c *ControllerHTTPClient) SetRequestedFloor(floor int) {
-	// TODO implement rest call to controller's SetRequestedFloor entry
+// SetRequestedFloor send a floor request to the controller, for the requester in ctx
+func (c *ControllerHTTPClient) SetRequestedFloor(ctx context.Context, floor int) error {
+	return c.call(ctx, "PUT", withRequester(ctx, fmt.Sprintf("/controller/requested_floor/%d", floor)), nil)
 }
 
 // SetLastSeenFloor tell the controller that the platform has arrived at a floor
-func (c *ControllerHTTPClient) SetLastSeenFloor(floor int) {
-	// TODO implement rest call to controller's SetLastSeenFloor entry
+func (c *ControllerHTTPClient) SetLastSeenFloor(ctx context.Context, floor int) error {
+	return c.call(ctx, "PUT", fmt.Sprintf("/controller/last_seen_floor/%d", floor), nil)
+}
+
+// SetDepartedFloor tell the controller that the platform has left a floor
+func (c *ControllerHTTPClient) SetDepartedFloor(ctx context.Context, floor int) error {
+	return c.call(ctx, "PUT", fmt.Sprintf("/controller/departed_floor/%d", floor), nil)
+}
+
+// SetStopRequested emergency stop the car, for the requester in ctx
+func (c *ControllerHTTPClient) SetStopRequested(ctx context.Context) error {
+	return c.call(ctx, "POST", withRequester(ctx, "/controller/stop"), nil)
+}
+
+// GetStatus get the controller's status
+func (c *ControllerHTTPClient) GetStatus(ctx context.Context) (*controller.Status, error) {
+	status := &controller.Status{}
+	if err := c.call(ctx, "GET", "/controller/status", status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetCalls get the controller's pending calls
+func (c *ControllerHTTPClient) GetCalls(ctx context.Context) ([]controller.Call, error) {
+	var calls []controller.Call
+	if err := c.call(ctx, "GET", "/controller/calls", &calls); err != nil {
+		return nil, err
+	}
+	return calls, nil
+}
+
+// CancelCall cancel floor's pending call
+func (c *ControllerHTTPClient) CancelCall(ctx context.Context, floor int) error {
+	return c.call(ctx, "DELETE", fmt.Sprintf("/controller/calls/%d", floor), nil)
+}
+
+// SetMaintenance take the car out of service, or put it back in service
+func (c *ControllerHTTPClient) SetMaintenance(ctx context.Context, maintenance bool) error {
+	return c.call(ctx, "PUT", fmt.Sprintf("/controller/maintenance?value=%t", maintenance), nil)
+}
+
+// ResetFault clear the controller's fault or emergency stop
+func (c *ControllerHTTPClient) ResetFault(ctx context.Context) error {
+	return c.call(ctx, "POST", "/controller/reset", nil)
+}
+
+// withRequester add the requester in ctx to path's query
+func withRequester(ctx context.Context, path string) string {
+	if requester := api.RequesterFrom(ctx); requester != "" {
+		path += "?" + url.Values{api.RequesterParam: {requester}}.Encode()
+	}
+	return path
+}
+
+// CheckHealth check that the controller's service is up, the health check is not retried
+func (c *ControllerHTTPClient) CheckHealth(ctx context.Context) error {
+	_, err := c.do(ctx, "GET", "/controller/health", nil)
+	return err
+}
+
+// call make the request, retrying transient failures with backoff, and decode the response
+// into response (when it isn't nil).  A controller that can't be reached by the last retry
+// is reported with an UnreachableError, unless ctx ended first.
+func (c *ControllerHTTPClient) call(ctx context.Context, method string, path string, response interface{}) error {
+	backoff := c.config.Backoff
+	for attempt := 0; ; attempt++ {
+		transient, err := c.do(ctx, method, path, response)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%s %s: %w", method, path, ctxErr)
+		}
+		if err == nil {
+			return nil
+		}
+		if !transient {
+			return fmt.Errorf("%s %s: %w", method, path, err)
+		}
+		if attempt >= c.config.Retries {
+			return fmt.Errorf("%s %s: %w", method, path, &UnreachableError{Err: err})
+		}
+		log.Warnf("%s %s failed, retrying in %s: %v", method, path, backoff, err)
+		select {
+		case <-c.clock.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s: %w", method, path, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// do make a single attempt at the request, a failure is transient when the controller
+// couldn't be reached or had a server side error
+func (c *ControllerHTTPClient) do(ctx context.Context, method string, path string, response interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+path, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &StatusError{StatusCode: resp.StatusCode, Message: string(body)}
+		var errorResponse api.ErrorResponse
+		if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Error != "" {
+			statusErr.Code, statusErr.Message = errorResponse.Code, errorResponse.Error
+		}
+		return statusErr.transient(), statusErr
+	}
+	if response != nil {
+		if err := json.Unmarshal(body, response); err != nil {
+			return false, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return false, nil
+}
+
+// ControllerHTTPClient constructor setters for builder pattern
+
+// SetConfig set the client's timeout and retries
+func (c *ControllerHTTPClient) SetConfig(config ClientConfig) *ControllerHTTPClient {
+	c.config = config
+	c.client = &http.Client{Timeout: config.Timeout}
+	return c
 }
 
-//SetStopRequested tell the controller to stop
-func (c *ControllerHTTPClient) SetStopRequested() {
-	//TODO implement send stop request controller
+// SetClock used by testing to skip the backoff waits
+func (c *ControllerHTTPClient) SetClock(clock common.Clock) *ControllerHTTPClient {
+	c.clock = clock
+	return c
 }
@@ -0,0 +1,200 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
+)
+
+var ctx = context.Background()
+
+var testClientConfig = ClientConfig{Timeout: 100 * time.Millisecond, Retries: 2, Backoff: 10 * time.Millisecond}
+
+// sleepRecorder a clock that records the backoff waits instead of waiting
+type sleepRecorder struct {
+	common.Clock
+	sleeps []time.Duration
+}
+
+func (s *sleepRecorder) After(d time.Duration) <-chan time.Time {
+	s.sleeps = append(s.sleeps, d)
+	after := make(chan time.Time, 1)
+	after <- s.Now()
+	return after
+}
+
+// scriptedController a stand in for the controller that answers each request with the next
+// of its status codes (repeating the last), and counts the requests
+type scriptedController struct {
+	codes    []int
+	requests []string
+	mu       sync.Mutex
+}
+
+func (s *scriptedController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	code := s.codes[len(s.codes)-1]
+	if len(s.requests) <= len(s.codes) {
+		code = s.codes[len(s.requests)-1]
+	}
+	w.WriteHeader(code)
+	if code != http.StatusOK {
+		w.Write([]byte(`{"Error": "scripted failure"}`))
+	}
+}
+
+func newScriptedClient(codes ...int) (*ControllerHTTPClient, *scriptedController, *sleepRecorder, func()) {
+	scripted := &scriptedController{codes: codes}
+	server := httptest.NewServer(scripted)
+	clock := &sleepRecorder{Clock: common.RealClock}
+	return NewControllerHTTPClient(server.URL).SetConfig(testClientConfig).SetClock(clock), scripted, clock, server.Close
+}
+
+// TestAgainstController the client's calls reach the controller's endpoints
+func TestAgainstController(t *testing.T) {
+	dwc := controller.NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerStop}))
+	router := mux.NewRouter()
+	api.NewHTTPController(dwc).AddEndpoints(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	client := NewControllerHTTPClient(server.URL).SetConfig(testClientConfig)
+
+	assert.NoError(t, client.SetLastSeenFloor(ctx, 1))
+	assert.NoError(t, client.SetRequestedFloor(ctx, 3))
+	status, err := client.GetStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, status.LastSeenFloor)
+	assert.Equal(t, 3, status.RequestedFloor)
+	assert.True(t, status.AtFloor)
+
+	assert.NoError(t, client.SetRequestedFloor(api.WithRequester(ctx, "floor2"), 2))
+	calls, err := client.GetCalls(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, calls, 2)
+	assert.Equal(t, "floor2", calls[1].Requester)
+	assert.NoError(t, client.CancelCall(ctx, 2))
+	assert.True(t, errors.Is(client.CancelCall(ctx, 2), api.ErrNoCall))
+
+	assert.NoError(t, client.SetDepartedFloor(ctx, 1))
+	assert.False(t, dwc.IsAtFloor())
+	assert.NoError(t, client.SetStopRequested(api.WithRequester(ctx, "floor3")))
+	assert.Equal(t, "floor3", dwc.GetEmergencyStop().Requester)
+	assert.True(t, errors.Is(client.SetRequestedFloor(ctx, 2), api.ErrEmergencyStopped))
+	assert.NoError(t, client.ResetFault(ctx))
+	assert.Equal(t, controller.Idle, dwc.GetState())
+
+	err = client.SetRequestedFloor(ctx, 4)
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusBadRequest, statusErr.StatusCode)
+	assert.Equal(t, "invalid floor: floor 4 is not between 1 and 3", statusErr.Message)
+	assert.True(t, errors.Is(err, api.ErrInvalidFloor))
+}
+
+// TestRetryTransientFailures server errors are retried with a doubling backoff
+func TestRetryTransientFailures(t *testing.T) {
+	client, scripted, clock, done := newScriptedClient(http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK)
+	defer done()
+
+	assert.NoError(t, client.SetRequestedFloor(ctx, 2))
+	assert.Equal(t, []string{"PUT /controller/requested_floor/2", "PUT /controller/requested_floor/2", "PUT /controller/requested_floor/2"}, scripted.requests)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, clock.sleeps)
+}
+
+// TestRetriesAreBounded a controller that keeps failing gets the first attempt and the retries
+func TestRetriesAreBounded(t *testing.T) {
+	client, scripted, _, done := newScriptedClient(http.StatusBadGateway)
+	defer done()
+
+	err := client.SetStopRequested(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "scripted failure")
+	assert.Len(t, scripted.requests, 3)
+}
+
+// TestRejectedRequestNotRetried a request the controller rejects won't work if it's tried again
+func TestRejectedRequestNotRetried(t *testing.T) {
+	client, scripted, clock, done := newScriptedClient(http.StatusBadRequest)
+	defer done()
+
+	assert.Error(t, client.SetLastSeenFloor(ctx, 2))
+	assert.Len(t, scripted.requests, 1)
+	assert.Empty(t, clock.sleeps)
+}
+
+// TestTimeout a controller that doesn't answer in time is retried, then reported
+func TestTimeout(t *testing.T) {
+	attempts := 0
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer server.Close()
+	client := NewControllerHTTPClient(server.URL).SetConfig(testClientConfig).SetClock(&sleepRecorder{Clock: common.RealClock})
+
+	start := time.Now()
+	assert.Error(t, client.SetRequestedFloor(ctx, 1))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "each attempt should time out")
+	mu.Lock()
+	assert.Equal(t, 3, attempts)
+	mu.Unlock()
+}
+
+// TestUnreachable a controller that isn't running is reported
+func TestUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client := NewControllerHTTPClient(server.URL).SetConfig(testClientConfig).SetClock(&sleepRecorder{Clock: common.RealClock})
+
+	_, err := client.GetStatus(ctx)
+	assert.True(t, errors.Is(err, api.ErrControllerUnreachable))
+	assert.Error(t, client.CheckHealth(ctx))
+}
+
+// TestControllerFault a controller in fault rejects the request without retries
+func TestControllerFault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"Error": "controller in fault: stalled", "Code": "controller_fault"}`))
+	}))
+	defer server.Close()
+	client := NewControllerHTTPClient(server.URL).SetConfig(testClientConfig)
+
+	err := client.SetRequestedFloor(ctx, 2)
+	assert.True(t, errors.Is(err, api.ErrControllerFault))
+	assert.False(t, errors.Is(err, api.ErrControllerUnreachable))
+}
+
+// TestCancelledCall a call gives up when its context is cancelled, even while it is waiting
+// to retry
+func TestCancelledCall(t *testing.T) {
+	client, scripted, _, done := newScriptedClient(http.StatusServiceUnavailable)
+	defer done()
+	client.SetConfig(ClientConfig{Timeout: time.Second, Retries: 5, Backoff: time.Hour}).SetClock(common.RealClock)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	err := client.SetStopRequested(cancelled)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, errors.Is(err, api.ErrControllerUnreachable))
+	assert.Len(t, scripted.requests, 1)
+}
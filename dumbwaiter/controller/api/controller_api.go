@@ -1,9 +1,85 @@
 package api
 
+import (
+	"context"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+)
+
 // Controller clients should use this interface when interacting with the controller
-// an http client that implements this interface will be provided.
+// an http client that implements this interface will be provided.  Calls give up when
+// ctx is done, errors can be matched with errors.Is against the Err values below.
 type Controller interface {
-	SetRequestedFloor(floor int)
-	SetLastSeenFloor(floor int)
-	SetStopRequested()
+	SetRequestedFloor(ctx context.Context, floor int) error
+	SetLastSeenFloor(ctx context.Context, floor int) error
+	SetDepartedFloor(ctx context.Context, floor int) error
+	SetStopRequested(ctx context.Context) error
+}
+
+// requesterKey the context key for the requester
+type requesterKey struct{}
+
+// WithRequester name who is making the calls made with ctx, the controller queues calls with
+// their requester
+func WithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// RequesterFrom the requester named in ctx, empty when there isn't one
+func RequesterFrom(ctx context.Context) string {
+	requester, _ := ctx.Value(requesterKey{}).(string)
+	return requester
+}
+
+// the controller's errors, see the controller package
+var (
+	ErrInvalidFloor          = controller.ErrInvalidFloor
+	ErrControllerFault       = controller.ErrControllerFault
+	ErrControllerUnreachable = controller.ErrControllerUnreachable
+	ErrNoCall                = controller.ErrNoCall
+	ErrIllegalTransition     = controller.ErrIllegalTransition
+	ErrPositionUnknown       = controller.ErrPositionUnknown
+	ErrEmergencyStopped      = controller.ErrEmergencyStopped
+)
+
+// InProcessController calls a controller running in the same process
+type InProcessController struct {
+	controller *controller.Controller
+}
+
+// NewInProcessController wrap controller so it can be called through the Controller interface
+func NewInProcessController(controller *controller.Controller) *InProcessController {
+	return &InProcessController{controller: controller}
+}
+
+// SetRequestedFloor call the car to floor, for the requester in ctx
+func (c *InProcessController) SetRequestedFloor(ctx context.Context, floor int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.controller.AddCall(floor, RequesterFrom(ctx))
+}
+
+// SetLastSeenFloor tell the controller that the car has arrived at floor
+func (c *InProcessController) SetLastSeenFloor(ctx context.Context, floor int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.controller.SetLastSeenFloor(floor)
+}
+
+// SetDepartedFloor tell the controller that the car has left floor
+func (c *InProcessController) SetDepartedFloor(ctx context.Context, floor int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.controller.SetDepartedFloor(floor)
+}
+
+// SetStopRequested emergency stop the car, for the requester in ctx
+func (c *InProcessController) SetStopRequested(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.controller.EmergencyStop(RequesterFrom(ctx))
 }
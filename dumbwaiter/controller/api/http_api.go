@@ -2,8 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -17,6 +19,28 @@ type HTTPController struct {
 	ServiceName string
 }
 
+// ErrorResponse the body returned with a failed request
+type ErrorResponse struct {
+	Error string
+	Code  string `json:",omitempty"` // identifies the errors clients can act on, e.g. InvalidFloorCode
+}
+
+// error codes for the controller's errors
+const (
+	InvalidFloorCode    = "invalid_floor"
+	ControllerFaultCode = "controller_fault"
+	NoCallCode          = "no_call"
+	IllegalStateCode    = "illegal_transition"
+	PositionUnknownCode = "position_unknown"
+	EmergencyStopCode   = "emergency_stopped"
+)
+
+// errInvalidValue a query parameter that couldn't be parsed
+var errInvalidValue = errors.New("invalid value")
+
+// RequesterParam the requested floor and stop query parameter naming who made the request
+const RequesterParam = "requester"
+
 // NewHTTPController wrap the controller with http entrypoints
 func NewHTTPController(controller *controller.Controller) *HTTPController {
 	return &HTTPController{Controller: controller, ServiceName: "controller"}
@@ -26,15 +50,176 @@ func NewHTTPController(controller *controller.Controller) *HTTPController {
 func (c *HTTPController) AddEndpoints(router *mux.Router) {
 	log.Info("adding controller service endpoints")
 	router.HandleFunc(fmt.Sprintf("/%s/status", c.ServiceName), c.StatusEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/requested_floor/{floor}", c.ServiceName), c.RequestedFloorEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/last_seen_floor/{floor}", c.ServiceName), c.LastSeenFloorEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/departed_floor/{floor}", c.ServiceName), c.DepartedFloorEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/stop", c.ServiceName), c.StopEndpoint).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/%s/calls", c.ServiceName), c.CallsEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/calls/{floor}", c.ServiceName), c.CancelCallEndpoint).Methods("DELETE")
+	router.HandleFunc(fmt.Sprintf("/%s/state_history", c.ServiceName), c.StateHistoryEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/maintenance", c.ServiceName), c.MaintenanceEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/reset", c.ServiceName), c.ResetEndpoint).Methods("POST")
 }
 
 // StatusEndpoint implement the http entry for status requests
 func (c *HTTPController) StatusEndpoint(w http.ResponseWriter, r *http.Request) {
 	log.Info("StatusEndpoint request received")
+	c.writeStatus(w)
+}
 
-	status := c.Controller.GetStatus()
+// RequestedFloorEndpoint implement the http entry for calling the car to a floor, the call is
+// queued with the requester query parameter
+func (c *HTTPController) RequestedFloorEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("RequestedFloorEndpoint request received")
+	floor, err := floorParam(r)
+	if err == nil {
+		err = c.Controller.AddCall(floor, r.URL.Query().Get(RequesterParam))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
 
+// LastSeenFloorEndpoint implement the http entry for a floor reporting the car has arrived
+func (c *HTTPController) LastSeenFloorEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("LastSeenFloorEndpoint request received")
+	floor, err := floorParam(r)
+	if err == nil {
+		err = c.Controller.SetLastSeenFloor(floor)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// DepartedFloorEndpoint implement the http entry for a floor reporting the car has left
+func (c *HTTPController) DepartedFloorEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("DepartedFloorEndpoint request received")
+	floor, err := floorParam(r)
+	if err == nil {
+		err = c.Controller.SetDepartedFloor(floor)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// StopEndpoint implement the http entry for emergency stops, the stop is logged with the
+// requester query parameter
+func (c *HTTPController) StopEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("StopEndpoint request received")
+	if err := c.Controller.EmergencyStop(r.URL.Query().Get(RequesterParam)); err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// CallsEndpoint implement the http entry for listing the pending calls
+func (c *HTTPController) CallsEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("CallsEndpoint request received")
 	w.Header().Add("Content-Type", "application/json")
-	log.Infof("StatusEndpoint returning: %v", status)
+	json.NewEncoder(w).Encode(c.Controller.GetCalls())
+}
+
+// CancelCallEndpoint implement the http entry for cancelling a floor's pending call
+func (c *HTTPController) CancelCallEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("CancelCallEndpoint request received")
+	floor, err := floorParam(r)
+	if err == nil {
+		err = c.Controller.CancelCall(floor)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// StateHistoryEndpoint implement the http entry for listing the latest state changes
+func (c *HTTPController) StateHistoryEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("StateHistoryEndpoint request received")
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Controller.GetStateHistory())
+}
+
+// MaintenanceEndpoint implement the http entry for taking the car out of service (value=true)
+// or putting it back (value=false)
+func (c *HTTPController) MaintenanceEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("MaintenanceEndpoint request received")
+	param := r.URL.Query().Get("value")
+	maintenance, err := strconv.ParseBool(param)
+	if err != nil {
+		err = fmt.Errorf("%w: maintenance %q is not true or false", errInvalidValue, param)
+	} else {
+		err = c.Controller.SetMaintenance(maintenance)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// ResetEndpoint implement the http entry for clearing a fault or an emergency stop, the fault
+// first when there are both
+func (c *HTTPController) ResetEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("ResetEndpoint request received")
+	reset := c.Controller.ResetEmergencyStop
+	if c.Controller.GetState() == controller.Fault {
+		reset = c.Controller.ResetFault
+	}
+	if err := reset(); err != nil {
+		writeError(w, err)
+		return
+	}
+	c.writeStatus(w)
+}
+
+// floorParam the request's floor
+func floorParam(r *http.Request) (int, error) {
+	param := mux.Vars(r)["floor"]
+	floor, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("%w: floor %q is not a number", ErrInvalidFloor, param)
+	}
+	return floor, nil
+}
+
+func (c *HTTPController) writeStatus(w http.ResponseWriter) {
+	status := c.Controller.GetStatus()
+	w.Header().Add("Content-Type", "application/json")
+	log.Infof("returning: %v", status)
 	json.NewEncoder(w).Encode(status)
 }
+
+// writeError return the error as json, with the status code and error code that match it
+func writeError(w http.ResponseWriter, err error) {
+	log.Warnf("request failed: %v", err)
+	code, response := http.StatusInternalServerError, ErrorResponse{Error: err.Error()}
+	switch {
+	case errors.Is(err, ErrInvalidFloor):
+		code, response.Code = http.StatusBadRequest, InvalidFloorCode
+	case errors.Is(err, ErrControllerFault):
+		code, response.Code = http.StatusConflict, ControllerFaultCode
+	case errors.Is(err, ErrNoCall):
+		code, response.Code = http.StatusNotFound, NoCallCode
+	case errors.Is(err, ErrPositionUnknown):
+		code, response.Code = http.StatusServiceUnavailable, PositionUnknownCode
+	case errors.Is(err, ErrEmergencyStopped):
+		code, response.Code = http.StatusConflict, EmergencyStopCode
+	case errors.Is(err, ErrIllegalTransition):
+		code, response.Code = http.StatusConflict, IllegalStateCode
+	case errors.Is(err, errInvalidValue):
+		code = http.StatusBadRequest
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+)
+
+// setup a three floor controller (its loop isn't started) behind the http endpoints
+func setup(t *testing.T) (*controller.Controller, *mux.Router) {
+	dwc := controller.NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", nil))
+	router := mux.NewRouter()
+	NewHTTPController(dwc).AddEndpoints(router)
+	return dwc, router
+}
+
+func request(router *mux.Router, method string, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func decodeStatus(t *testing.T, w *httptest.ResponseRecorder) controller.Status {
+	var status controller.Status
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	return status
+}
+
+// TestRequestedFloorEndpoint a valid floor is requested and the resulting status returned
+func TestRequestedFloorEndpoint(t *testing.T) {
+	dwc, router := setup(t)
+
+	w := request(router, "PUT", "/controller/requested_floor/3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 3, decodeStatus(t, w).RequestedFloor)
+	assert.Equal(t, 3, dwc.GetRequestedFloor())
+}
+
+// TestCallsEndpoints calls are queued with their requester, listed and cancelled
+func TestCallsEndpoints(t *testing.T) {
+	dwc, router := setup(t)
+
+	assert.Equal(t, http.StatusOK, request(router, "PUT", "/controller/requested_floor/3?requester=floor1").Code)
+	w := request(router, "GET", "/controller/calls")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var calls []controller.Call
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&calls))
+	assert.Len(t, calls, 1)
+	assert.Equal(t, 3, calls[0].Floor)
+	assert.Equal(t, "floor1", calls[0].Requester)
+
+	w = request(router, "DELETE", "/controller/calls/3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeStatus(t, w).PendingCalls)
+	assert.Empty(t, dwc.GetCalls())
+
+	w = request(router, "DELETE", "/controller/calls/3")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var response ErrorResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, NoCallCode, response.Code)
+}
+
+// TestMaintenanceEndpoint the car is taken out of service and put back, the changes are in the
+// state history
+func TestMaintenanceEndpoint(t *testing.T) {
+	dwc, router := setup(t)
+
+	w := request(router, "PUT", "/controller/maintenance?value=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, controller.Maintenance, decodeStatus(t, w).State)
+	assert.Equal(t, http.StatusOK, request(router, "PUT", "/controller/maintenance?value=false").Code)
+	assert.Equal(t, controller.Idle, dwc.GetState())
+	assert.Equal(t, http.StatusBadRequest, request(router, "PUT", "/controller/maintenance?value=soon").Code)
+
+	w = request(router, "GET", "/controller/state_history")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var history []controller.StateChange
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	assert.Len(t, history, 2)
+	assert.Equal(t, controller.Maintenance, history[0].To)
+
+	dwc.SetMovingDirection(controller.Up)
+	w = request(router, "PUT", "/controller/maintenance?value=true")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var response ErrorResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, IllegalStateCode, response.Code)
+}
+
+// TestResetEndpoint a controller in fault refuses calls until it is reset
+func TestResetEndpoint(t *testing.T) {
+	dwc := controller.NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerStop}))
+	router := mux.NewRouter()
+	NewHTTPController(dwc).AddEndpoints(router)
+	dwc.SetLastSeenFloor(1)
+	dwc.SetRequestedFloor(2)
+	dwc.SetLastSeenFloor(3) // the car went past floor 2
+
+	w := request(router, "PUT", "/controller/requested_floor/2")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var response ErrorResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, ControllerFaultCode, response.Code)
+
+	w = request(router, "GET", "/controller/status")
+	assert.Equal(t, controller.FaultOverrun, decodeStatus(t, w).Fault.Reason)
+	w = request(router, "POST", "/controller/reset")
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := decodeStatus(t, w)
+	assert.Equal(t, controller.Idle, status.State)
+	assert.Nil(t, status.Fault)
+	assert.Equal(t, http.StatusOK, request(router, "PUT", "/controller/requested_floor/2").Code)
+}
+
+// TestLastSeenAndDepartedFloorEndpoints floors report the car's arrivals and departures
+func TestLastSeenAndDepartedFloorEndpoints(t *testing.T) {
+	_, router := setup(t)
+
+	w := request(router, "PUT", "/controller/last_seen_floor/2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := decodeStatus(t, w)
+	assert.Equal(t, 2, status.LastSeenFloor)
+	assert.True(t, status.AtFloor)
+
+	w = request(router, "PUT", "/controller/departed_floor/2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, decodeStatus(t, w).AtFloor)
+}
+
+// TestStopEndpoint a stop emergency stops the car for the requester, calls are refused until
+// it is reset
+func TestStopEndpoint(t *testing.T) {
+	dwc := controller.NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerStop}))
+	router := mux.NewRouter()
+	NewHTTPController(dwc).AddEndpoints(router)
+	dwc.SetLastSeenFloor(2)
+	dwc.SetRequestedFloor(3)
+
+	w := request(router, "POST", "/controller/stop?requester=floor1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	status := decodeStatus(t, w)
+	assert.Equal(t, controller.EmergencyStopped, status.State)
+	assert.Equal(t, 0, status.RequestedFloor)
+	assert.Empty(t, status.PendingCalls)
+	assert.Equal(t, "floor1", status.EmergencyStop.Requester)
+
+	w = request(router, "PUT", "/controller/requested_floor/3")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	var response ErrorResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, EmergencyStopCode, response.Code)
+
+	w = request(router, "POST", "/controller/reset")
+	assert.Equal(t, http.StatusOK, w.Code)
+	status = decodeStatus(t, w)
+	assert.Equal(t, controller.Idle, status.State)
+	assert.Nil(t, status.EmergencyStop)
+}
+
+// TestInvalidFloors floors outside 1..topFloor, or that aren't numbers, are rejected with a
+// json error and leave the controller alone
+func TestInvalidFloors(t *testing.T) {
+	dwc, router := setup(t)
+
+	for _, path := range []string{"/controller/requested_floor/0", "/controller/requested_floor/4",
+		"/controller/requested_floor/two", "/controller/last_seen_floor/-1", "/controller/departed_floor/9"} {
+		w := request(router, "PUT", path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"), path)
+		var response ErrorResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&response), path)
+		assert.NotEmpty(t, response.Error, path)
+		assert.Equal(t, InvalidFloorCode, response.Code, path)
+	}
+	assert.Equal(t, 0, dwc.GetRequestedFloor())
+	assert.Equal(t, 0, dwc.GetLastSeenFloor())
+}
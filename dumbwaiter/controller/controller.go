@@ -1,12 +1,14 @@
 package controller
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/drive"
 )
 
 var defaultLoopFrequency time.Duration = 500 * time.Millisecond
@@ -27,45 +29,110 @@ func (d Direction) String() string {
 
 // Status returns the current status of the dumbwaiter
 type Status struct {
-	MovingDirection Direction
-	RequestedFloor  int
-	LastSeenFloor   int
+	MovingDirection  Direction
+	RequestedFloor   int
+	LastSeenFloor    int
+	AtFloor          bool    // the car is still at LastSeenFloor, false once it has departed
+	CarPosition      float64 // the car's position in floors from drives that track it, otherwise 0
+	PendingCalls     []Call  // the calls that haven't been served, in the order they were made
+	State            State
+	StateHistory     []StateChange        // the latest state changes, oldest first
+	Fault            *FaultStatus         `json:",omitempty"` // the latched fault while State is Fault
+	Homing           *HomingStatus        `json:",omitempty"` // the latest homing, when the controller started without knowing where the car is
+	EmergencyStop    *EmergencyStopStatus `json:",omitempty"` // the latched emergency stop while State is EmergencyStopped
+	WaitingToReverse bool                 // the car is held back from reversing by the minimum run time or reverse delay
+	Config           Config               // the controller's settings
+	TopLimit         bool                 // the top limit switch is tripped
+	BottomLimit      bool                 // the bottom limit switch is tripped
 
 	// TODO add array of floors' status
 }
 
-// Controller sends up, down, stop signals to the garage door opener based on getting
-// control directives from the dumbwaiter floor services and/or the web app
+// Config the controller's timing and home floor settings
+type Config struct {
+	DwellTime       time.Duration // how long the car stays at a floor it arrived at, calls are held until it is up
+	HomeFloor       int           // the floor an idle car returns to, 0 for none
+	HomeIdleTime    time.Duration // how long the car is idle before it returns to HomeFloor
+	MinReverseDelay time.Duration // how long a stopped car rests before it is started the other way
+	MinRunTime      time.Duration // how long a started car runs before it can be stopped to reverse
+}
+
+// Controller sends up, down, stop commands to the drive (by default the garage door opener) based on
+// getting control directives from the dumbwaiter floor services and/or the web app
 type Controller struct {
-	lastSeenFloor    int // the last floor reporting the car was seen at
+	lastSeenFloor    int  // the last floor reporting the car was seen at
+	atFloor          bool // the car has not departed lastSeenFloor
 	lastSeenFloorMU  sync.RWMutex
-	requestedFloor   int // the car should move to this floor
+	requestedFloor   int       // the car should move to this floor, the pending call being served
+	calls            callQueue // the pending calls
 	requestedFloorMU sync.RWMutex
 
 	topFloor int // the top floor number (floor numbers start at 1)
 
-	movingDirection   Direction // the direction the cab is currently moving
-	movingDirectionMu sync.RWMutex
-
-	timeToMoveOneFloor time.Duration
+	state            State                // the controller's state, the cab's moving direction comes from it
+	stateHistory     []StateChange        // the latest state changes, at most stateHistorySize
+	travelDirection  Direction            // the direction the cab last moved, the way it keeps going while there are calls ahead
+	movingSince      time.Time            // when the cab started moving or last reached a floor
+	startedAt        time.Time            // when the cab last started moving
+	stoppedAt        time.Time            // when the cab last stopped
+	dwellUntil       time.Time            // when the cab that arrived at a floor can leave it
+	idleSince        time.Time            // when the controller last became idle
+	waitingToReverse bool                 // the last dispatch was held back from reversing the cab too soon
+	fault            *FaultStatus         // the latched fault
+	homing           *HomingStatus        // the latest homing
+	emergencyStop    *EmergencyStopStatus // the latched emergency stop
+	topLimit         bool                 // the limit switch past the top floor is tripped
+	bottomLimit      bool                 // the limit switch past the bottom floor is tripped
+	stateMu          sync.RWMutex
+
+	policy DispatchPolicy // picks the call to serve next
+
+	timeToMoveOneFloor time.Duration // the car has to reach the next floor in this time, or it has stalled
+	minReverseDelay    time.Duration // a stopped car rests this long before it is started the other way
+	minRunTime         time.Duration // a started car runs this long before it is stopped to reverse
+	dwellTime          time.Duration // the car stays this long at a floor it arrived at
+	homeFloor          int           // an idle car returns to this floor, 0 for none
+	homeIdleTime       time.Duration // the car is idle this long before it returns to homeFloor
+	clock              common.Clock
+
+	referenceFloor int        // the car is homed towards this floor
+	floorQuery     FloorQuery // asks the floors where the car is, nil when they can't be asked
+
+	limitSwitches bool // the RPi has limit switches wired past the end floors
+	limitsPolled  bool // the limit switches can't be watched, they are read every loop
 
 	mainLoopTicker *time.Ticker
 	mainLoopFreq   time.Duration
-	piDevice       common.RPi // the interface with the raspberry pi device
+	piDevice       common.RPi  // the interface with the raspberry pi device
+	motor          drive.Drive // moves the car
 }
 
 // NewController make a Controller object
 func NewController(maxFloors int) *Controller {
-	piDevice := common.NewRPiDevice()
+	piDevice := common.NewPulsedRPi(common.NewRPiDevice(common.DefaultPinMap()), common.DefaultOpenerPulseConfig)
 	return &Controller{
-		topFloor:        maxFloors,
-		piDevice:        piDevice,
-		movingDirection: Stopped,
-		mainLoopFreq:    defaultLoopFrequency}
+		topFloor:           maxFloors,
+		piDevice:           piDevice,
+		motor:              drive.NewThreeRelayOpener(piDevice),
+		state:              Idle,
+		travelDirection:    Up,
+		policy:             ScanPolicy{},
+		timeToMoveOneFloor: defaultTimeToMoveOneFloor,
+		minReverseDelay:    defaultMinReverseDelay,
+		minRunTime:         defaultMinRunTime,
+		referenceFloor:     1,
+		clock:              common.RealClock,
+		mainLoopFreq:       defaultLoopFrequency}
 }
 
 // StartProcessingLoop start the processing loop in its own goroutine
 func (c *Controller) StartProcessingLoop() {
+	if c.limitSwitches {
+		c.startLimitSwitches()
+	}
+	c.stateMu.Lock()
+	c.idleSince = c.clock.Now()
+	c.stateMu.Unlock()
 	go c.processingLoop()
 }
 
@@ -78,24 +145,46 @@ func (c *Controller) processingLoop() {
 	for {
 		select {
 		case <-c.mainLoopTicker.C:
-			// if the car is stationary and another floor is requested, start it moving in the requested direction
-			// if the car is moving and a floor in the opposite direction has been requested stop the car
-			// (let the next iteration start it moving)
-			if c.GetRequestedFloor() > c.GetLastSeenFloor() {
-				if c.GetMovingDirection() == Stopped {
-					c.sendUp()
-				} else if c.GetMovingDirection() == Down {
-					c.stop() // stop the machine, it start moving up on next iteration
-				}
-				// else do nothing it is already moving up
-			} else if c.GetRequestedFloor() < c.GetLastSeenFloor() {
-				if c.GetMovingDirection() == Stopped {
-					c.sendDown()
-				} else if c.GetMovingDirection() == Up {
-					c.stop() // stop the machine, it will start moving down on next iteration
+			if c.limitsPolled {
+				c.pollLimits()
+			}
+			if state := c.GetState(); state == Fault || state == Maintenance || state == EmergencyStopped {
+				continue // the car stays where it is
+			}
+			if c.checkTravelTime() {
+				continue
+			}
+			if c.GetState() == Homing {
+				c.home()
+				continue
+			}
+			if c.GetLastSeenFloor() == 0 {
+				c.startHoming()
+				continue
+			}
+			c.serveCall()
+			if c.isDwelling() {
+				continue // the calls are held until the car has dwelt at the floor
+			}
+			// the policy picks the floor to go to, and whether the drive has to start, stop
+			// (before it can reverse) or keep going
+			dispatch := c.dispatch()
+			if c.waitToReverse(dispatch) {
+				continue
+			}
+			switch dispatch.Command {
+			case UpCommand:
+				c.sendUp()
+			case DownCommand:
+				c.sendDown()
+			case StopCommand:
+				c.stop(dispatch.Floor)
+			case NoCommand:
+				if state := c.GetState(); state == Stopping || state == Dwelling {
+					c.changeState(Idle, "no call to serve")
+				} else if state == Idle {
+					c.returnHome()
 				}
-			} else if c.GetMovingDirection() != Stopped {
-				c.stop()
 			}
 		}
 	}
@@ -103,31 +192,109 @@ func (c *Controller) processingLoop() {
 
 // GetStatus get the dumbwaiter status
 func (c *Controller) GetStatus() *Status {
-	return &Status{
-		LastSeenFloor:   c.GetLastSeenFloor(),
-		MovingDirection: c.GetMovingDirection(),
-		RequestedFloor:  c.GetRequestedFloor(),
+	status := &Status{
+		LastSeenFloor:    c.GetLastSeenFloor(),
+		AtFloor:          c.IsAtFloor(),
+		MovingDirection:  c.GetMovingDirection(),
+		RequestedFloor:   c.GetRequestedFloor(),
+		PendingCalls:     c.GetCalls(),
+		State:            c.GetState(),
+		StateHistory:     c.GetStateHistory(),
+		Fault:            c.GetFault(),
+		Homing:           c.GetHoming(),
+		EmergencyStop:    c.GetEmergencyStop(),
+		WaitingToReverse: c.IsWaitingToReverse(),
+		Config:           c.GetConfig(),
 
 		// TODO add floors' status
 	}
+	if positioner, ok := c.motor.(drive.Positioner); ok {
+		if position, known := positioner.Position(); known {
+			status.CarPosition = position
+		}
+	}
+	status.TopLimit, status.BottomLimit = c.getLimits()
+	return status
 }
 
 func (c *Controller) sendUp() {
 	log.Info("controller sending up")
-	c.piDevice.SendSignal(common.OpenerUp)
-	c.SetMovingDirection(Up)
+	if err := c.checkState(MovingUp); err != nil {
+		log.Errorf("controller can't send up: %v", err)
+		return
+	}
+	if err := c.move(Up); err != nil {
+		log.Errorf("controller sending up failed, will retry: %v", err)
+		return
+	}
+	if err := c.changeState(MovingUp, "sent up"); err != nil {
+		// the state changed while the drive was being started, e.g. an emergency stop
+		if err := c.move(Stopped); err != nil {
+			log.Errorf("controller stopping the drive failed: %v", err)
+		}
+	}
 }
 
 func (c *Controller) sendDown() {
 	log.Info("controller sending down")
-	c.piDevice.SendSignal(common.OpenerDown)
-	c.SetMovingDirection(Down)
+	if err := c.checkState(MovingDown); err != nil {
+		log.Errorf("controller can't send down: %v", err)
+		return
+	}
+	if err := c.move(Down); err != nil {
+		log.Errorf("controller sending down failed, will retry: %v", err)
+		return
+	}
+	if err := c.changeState(MovingDown, "sent down"); err != nil {
+		// the state changed while the drive was being started, e.g. an emergency stop
+		if err := c.move(Stopped); err != nil {
+			log.Errorf("controller stopping the drive failed: %v", err)
+		}
+	}
 }
 
-func (c *Controller) stop() {
+// stop stop the car, it dwells when it is at floor (the requested floor) otherwise it is
+// stopping on its way somewhere else
+func (c *Controller) stop(floor int) {
 	log.Info("controller stopping")
-	c.piDevice.SendSignal(common.OpenerStop)
-	c.SetMovingDirection(Stopped)
+	to, reason := Stopping, "stopped away from the requested floor"
+	if c.GetLastSeenFloor() == floor && c.IsAtFloor() {
+		to, reason = Dwelling, fmt.Sprintf("arrived at floor %d", floor)
+	}
+	if err := c.move(Stopped); err != nil {
+		log.Errorf("controller stopping failed, will retry: %v", err)
+		return
+	}
+	c.changeState(to, reason)
+}
+
+// move get the drive moving in direction (or stopped), drives that can go to a floor by
+// themselves are sent straight to the requested floor
+func (c *Controller) move(direction Direction) error {
+	if mover, ok := c.motor.(drive.FloorMover); ok && direction != Stopped {
+		return mover.MoveToFloor(c.GetRequestedFloor())
+	}
+	switch direction {
+	case Up:
+		return c.motor.Up()
+	case Down:
+		return c.motor.Down()
+	}
+	return c.motor.Stop()
+}
+
+// cut stop the drive at once for a fault or an emergency, drives without a hard cut are
+// stopped
+func (c *Controller) cut() error {
+	if cutter, ok := c.motor.(drive.Cutter); ok {
+		return cutter.Cut()
+	}
+	return c.motor.Stop()
+}
+
+// GetConfig get the controller's settings
+func (c *Controller) GetConfig() Config {
+	return Config{DwellTime: c.dwellTime, HomeFloor: c.homeFloor, HomeIdleTime: c.homeIdleTime, MinReverseDelay: c.minReverseDelay, MinRunTime: c.minRunTime}
 }
 
 // GetLastSeenFloor return the floor the dumbwaiter's car was last seen at
@@ -137,12 +304,75 @@ func (c *Controller) GetLastSeenFloor() int {
 	return c.lastSeenFloor
 }
 
-// SetLastSeenFloor set floor number the dumbwaiter's car was last seen at
-func (c *Controller) SetLastSeenFloor(floor int) {
+// SetLastSeenFloor set floor number the dumbwaiter's car was last seen at, a car that went
+// past its requested floor without it being seen is in fault
+func (c *Controller) SetLastSeenFloor(floor int) error {
+	if err := c.checkFloor(floor); err != nil {
+		return err
+	}
 	log.Infof("controller setting last seen floor to %d", floor)
+	requestedFloor := c.GetRequestedFloor()
+	lastSeenFloor := c.setLastSeenFloor(floor)
+	c.checkOverrun(lastSeenFloor, floor, requestedFloor)
+	return nil
+}
+
+// setLastSeenFloor record the car's arrival at floor, return the floor it was last seen at before
+func (c *Controller) setLastSeenFloor(floor int) int {
 	c.lastSeenFloorMU.Lock()
 	defer c.lastSeenFloorMU.Unlock()
+	if observer, ok := c.motor.(drive.TravelObserver); ok && c.lastSeenFloor != 0 && floor != c.lastSeenFloor {
+		// the car's travel between floors shows which way the drive is really going
+		up := floor > c.lastSeenFloor
+		if observer.ObserveTravel(up) {
+			observed := MovingDown
+			if up {
+				observed = MovingUp
+			}
+			c.resyncState(observed, "the drive was seen going the other way")
+		}
+	}
+	c.stateMu.Lock()
+	c.movingSince = c.clock.Now()
+	c.stateMu.Unlock()
+	lastSeenFloor := c.lastSeenFloor
 	c.lastSeenFloor = floor
+	c.atFloor = true
+	return lastSeenFloor
+}
+
+// SetDepartedFloor the dumbwaiter's car has left floor
+func (c *Controller) SetDepartedFloor(floor int) error {
+	if err := c.checkFloor(floor); err != nil {
+		return err
+	}
+	log.Infof("controller setting departed floor %d", floor)
+	c.lastSeenFloorMU.Lock()
+	defer c.lastSeenFloorMU.Unlock()
+	if floor == c.lastSeenFloor {
+		c.atFloor = false
+	}
+	return nil
+}
+
+// IsAtFloor whether the dumbwaiter's car is still at the floor it was last seen at
+func (c *Controller) IsAtFloor() bool {
+	c.lastSeenFloorMU.RLock()
+	defer c.lastSeenFloorMU.RUnlock()
+	return c.atFloor
+}
+
+// GetTopFloor return the top floor number, floors are numbered from 1
+func (c *Controller) GetTopFloor() int {
+	return c.topFloor
+}
+
+// checkFloor check that floor is one of the dumbwaiter's floors
+func (c *Controller) checkFloor(floor int) error {
+	if floor < 1 || floor > c.topFloor {
+		return fmt.Errorf("%w: floor %d is not between 1 and %d", ErrInvalidFloor, floor, c.topFloor)
+	}
+	return nil
 }
 
 // GetRequestedFloor return the floor the dumbwaiter car should move to
@@ -152,42 +382,331 @@ func (c *Controller) GetRequestedFloor() int {
 	return c.requestedFloor
 }
 
-// SetRequestedFloor set the floor the dumbwaiter car should move to
-func (c *Controller) SetRequestedFloor(floor int) {
-	log.Infof("controller setting requested floor to %d", floor)
+// SetRequestedFloor call the car to floor, for a caller that didn't say who it is
+func (c *Controller) SetRequestedFloor(floor int) error {
+	return c.AddCall(floor, "")
+}
+
+// AddCall queue requester's call for the car to come to floor, a floor that already has a
+// pending call keeps it.  Calls are refused while the controller is in fault, emergency
+// stopped or homing.
+func (c *Controller) AddCall(floor int, requester string) error {
+	if err := c.checkFloor(floor); err != nil {
+		return err
+	}
+	if err := c.checkFault(); err != nil {
+		return err
+	}
+	if err := c.checkEmergencyStop(); err != nil {
+		return err
+	}
+	if err := c.checkPositionKnown(); err != nil {
+		return err
+	}
+	log.Infof("controller queueing call to floor %d from %q", floor, requester)
+	c.requestedFloorMU.Lock()
+	added := c.calls.add(Call{Floor: floor, Requester: requester, Time: c.clock.Now()})
+	c.requestedFloorMU.Unlock()
+	if !added {
+		log.Infof("floor %d already has a pending call", floor)
+	}
+	c.dispatch()
+	return nil
+}
+
+// CancelCall drop floor's pending call, ErrNoCall when it doesn't have one
+func (c *Controller) CancelCall(floor int) error {
+	if err := c.checkFloor(floor); err != nil {
+		return err
+	}
+	log.Infof("controller cancelling call to floor %d", floor)
+	c.requestedFloorMU.Lock()
+	removed := c.calls.remove(floor)
+	c.requestedFloorMU.Unlock()
+	if !removed {
+		return fmt.Errorf("%w: floor %d", ErrNoCall, floor)
+	}
+	c.dispatch()
+	return nil
+}
+
+// GetCalls the pending calls, in the order they were made
+func (c *Controller) GetCalls() []Call {
+	c.requestedFloorMU.RLock()
+	defer c.requestedFloorMU.RUnlock()
+	return c.calls.list()
+}
+
+// dispatch ask the policy where the car should go, its floor becomes the requested floor
+func (c *Controller) dispatch() Dispatch {
+	state := c.carState()
 	c.requestedFloorMU.Lock()
 	defer c.requestedFloorMU.Unlock()
-	c.requestedFloor = floor
+	state.RequestedFloor, state.Calls = c.requestedFloor, c.calls.list()
+	dispatch := c.policy.Next(state)
+	c.requestedFloor = dispatch.Floor
+	return dispatch
 }
 
-//SetStopRequested get a stop request from a floor sensor
-func (c *Controller) SetStopRequested() {
-	log.Infof("controller recieved a stop request")
+// serveCall the car is stopped at a floor with a pending call, that call has been served
+func (c *Controller) serveCall() {
+	if c.GetMovingDirection() != Stopped || !c.IsAtFloor() {
+		return
+	}
+	floor := c.GetLastSeenFloor()
 	c.requestedFloorMU.Lock()
 	defer c.requestedFloorMU.Unlock()
-	//when requested floor equals the last seen floor the controller will send a stop request
-	c.requestedFloor = c.lastSeenFloor
+	if c.calls.remove(floor) {
+		log.Infof("controller served the call to floor %d", floor)
+	}
+}
+
+// carState a snapshot of the car, without its calls
+func (c *Controller) carState() CarState {
+	car := CarState{
+		LastSeenFloor:   c.GetLastSeenFloor(),
+		AtFloor:         c.IsAtFloor(),
+		MovingDirection: c.GetMovingDirection(),
+		TravelDirection: c.getTravelDirection(),
+	}
+	car.TopLimit, car.BottomLimit = c.getLimits()
+	return car
 }
 
 // GetMovingDirection get the dumbwaiter's current direction
 func (c *Controller) GetMovingDirection() Direction {
-	c.movingDirectionMu.RLock()
-	defer c.movingDirectionMu.RUnlock()
-	return c.movingDirection
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.movingDirectionLocked()
 }
 
-// SetMovingDirection set the dumbwaiter's moving direction
+// movingDirectionLocked the car's direction from the state, or from homing while it is being
+// homed, the caller holds stateMu
+func (c *Controller) movingDirectionLocked() Direction {
+	if c.state == Homing && c.homing != nil {
+		return c.homing.Direction
+	}
+	return c.state.direction()
+}
+
+// SetMovingDirection set the dumbwaiter's moving direction, through the state machine: the
+// controller starts moving up or down, or a moving car is stopping
 func (c *Controller) SetMovingDirection(movingDirection Direction) {
-	c.movingDirectionMu.Lock()
-	defer c.movingDirectionMu.Unlock()
-	c.movingDirection = movingDirection
+	to := Stopping
+	switch movingDirection {
+	case Up:
+		to = MovingUp
+	case Down:
+		to = MovingDown
+	default:
+		if c.GetMovingDirection() == Stopped {
+			return // already stopped
+		}
+	}
+	c.changeState(to, fmt.Sprintf("moving direction set to %s", movingDirection))
+}
+
+// GetState get the controller's state
+func (c *Controller) GetState() State {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.state
+}
+
+// GetStateHistory get the latest state changes, oldest first
+func (c *Controller) GetStateHistory() []StateChange {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return append([]StateChange{}, c.stateHistory...)
+}
+
+// SetMaintenance take the car out of service, or put it back in service.  The car has to be
+// stopped to be taken out of service, a car taken out of service in fault goes back to it, as
+// does a car emergency stopped before or during the maintenance.
+func (c *Controller) SetMaintenance(maintenance bool) error {
+	if maintenance {
+		return c.changeState(Maintenance, "taken out of service")
+	}
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state != Maintenance {
+		return nil
+	}
+	if c.fault != nil {
+		return c.changeStateLocked(Fault, fmt.Sprintf("put back in service, the %s fault isn't reset", c.fault.Reason), car)
+	}
+	if c.emergencyStop != nil {
+		return c.changeStateLocked(EmergencyStopped, "put back in service, the emergency stop isn't reset", car)
+	}
+	return c.changeStateLocked(Idle, "put back in service", car)
+}
+
+// checkState nil when the controller can change to state to, otherwise why it can't
+func (c *Controller) checkState(to State) error {
+	car := c.carState()
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return checkTransition(c.state, to, car, c.topFloor)
+}
+
+// changeState change to state to when the transition table allows it, illegal transitions
+// are logged and rejected with ErrIllegalTransition
+func (c *Controller) changeState(to State, reason string) error {
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.changeStateLocked(to, reason, car)
+}
+
+// changeStateLocked changeState with car taken before stateMu was locked, for the callers
+// that change more than the state under the lock
+func (c *Controller) changeStateLocked(to State, reason string, car CarState) error {
+	if err := checkTransition(c.state, to, car, c.topFloor); err != nil {
+		log.Errorf("controller rejected state change (%s): %v", reason, err)
+		return err
+	}
+	c.setState(to, reason)
+	return nil
+}
+
+// resyncState change to the state the car is seen to be in, the transition table is for the
+// controller's own changes so it isn't checked
+func (c *Controller) resyncState(to State, reason string) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	log.Warnf("controller resyncing state from %s to %s: %s", c.state, to, reason)
+	c.setState(to, reason)
+}
+
+// setState record the change to state to, the caller holds stateMu
+func (c *Controller) setState(to State, reason string) {
+	if to == c.state {
+		return
+	}
+	log.Infof("controller state %s -> %s: %s", c.state, to, reason)
+	now := c.clock.Now()
+	c.stateHistory = append(c.stateHistory, StateChange{Time: now, From: c.state, To: to, Reason: reason})
+	if len(c.stateHistory) > stateHistorySize {
+		c.stateHistory = c.stateHistory[len(c.stateHistory)-stateHistorySize:]
+	}
+	if c.state.direction() == Stopped && to.direction() != Stopped {
+		c.movingSince, c.startedAt = now, now
+	}
+	if c.state.direction() != Stopped && to.direction() == Stopped {
+		c.stoppedAt = now
+	}
+	switch to {
+	case Dwelling:
+		c.dwellUntil = now.Add(c.dwellTime)
+	case Idle:
+		c.idleSince = now
+	}
+	c.state = to
+	if direction := to.direction(); direction != Stopped {
+		c.travelDirection = direction
+	}
+}
+
+// getTravelDirection the direction the car last moved
+func (c *Controller) getTravelDirection() Direction {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.travelDirection
 }
 
 // Controller constructor setters for builder pattern
 
-// SetRPiDevice used by testing to override production RPi interface
+// SetRPiDevice used by testing to override production RPi interface, the car is
+// driven by a three relay opener on the new device (use SetDrive after for other drives)
 func (c *Controller) SetRPiDevice(piDevice common.RPi) *Controller {
 	c.piDevice = piDevice
+	c.motor = drive.NewThreeRelayOpener(piDevice)
+	return c
+}
+
+// SetDrive set the drive that moves the car, drives that know when the car reaches a floor
+// report it as the last seen floor
+func (c *Controller) SetDrive(motor drive.Drive) *Controller {
+	c.motor = motor
+	if reporter, ok := motor.(drive.FloorReporter); ok {
+		reporter.OnFloor(func(floor int) {
+			if err := c.SetLastSeenFloor(floor); err != nil {
+				log.Errorf("drive reported an invalid floor: %v", err)
+			}
+		})
+	}
+	return c
+}
+
+// SetDispatchPolicy set the policy that picks the call to serve next (SCAN by default)
+func (c *Controller) SetDispatchPolicy(policy DispatchPolicy) *Controller {
+	c.policy = policy
+	return c
+}
+
+// SetTimeToMoveOneFloor set how long the car has to reach the next floor before it is stalled
+func (c *Controller) SetTimeToMoveOneFloor(timeToMoveOneFloor time.Duration) *Controller {
+	c.timeToMoveOneFloor = timeToMoveOneFloor
+	return c
+}
+
+// SetMinReverseDelay set how long a stopped car rests before it is started the other way
+func (c *Controller) SetMinReverseDelay(minReverseDelay time.Duration) *Controller {
+	c.minReverseDelay = minReverseDelay
+	return c
+}
+
+// SetMinRunTime set how long a started car runs before it can be stopped to reverse, a car
+// reaching its requested floor is stopped sooner
+func (c *Controller) SetMinRunTime(minRunTime time.Duration) *Controller {
+	c.minRunTime = minRunTime
+	return c
+}
+
+// SetDwellTime set how long the car stays at a floor it arrived at, calls made meanwhile are
+// held until it is up
+func (c *Controller) SetDwellTime(dwellTime time.Duration) *Controller {
+	c.dwellTime = dwellTime
+	return c
+}
+
+// SetHomeFloor set the floor the car returns to once it has been idle for idleTime, 0 for
+// none (the default).  A floor the controller doesn't have turns the return home off.
+func (c *Controller) SetHomeFloor(floor int, idleTime time.Duration) *Controller {
+	if floor != 0 {
+		if err := c.checkFloor(floor); err != nil {
+			log.Errorf("controller not returning the car home: %v", err)
+			floor = 0
+		}
+	}
+	c.homeFloor, c.homeIdleTime = floor, idleTime
+	return c
+}
+
+// SetReferenceFloor set the floor the car is homed towards when its position is unknown, the
+// bottom floor by default
+func (c *Controller) SetReferenceFloor(floor int) *Controller {
+	c.referenceFloor = floor
+	return c
+}
+
+// SetFloorQuery set how the floors are asked where the car is when its position is unknown
+func (c *Controller) SetFloorQuery(query FloorQuery) *Controller {
+	c.floorQuery = query
+	return c
+}
+
+// SetLimitSwitches set whether the RPi has TopLimit and BottomLimit switches wired, the
+// drive is cut when one of them trips
+func (c *Controller) SetLimitSwitches(limitSwitches bool) *Controller {
+	c.limitSwitches = limitSwitches
+	return c
+}
+
+// SetClock used by testing to control the travel time limits
+func (c *Controller) SetClock(clock common.Clock) *Controller {
+	c.clock = clock
 	return c
 }
 
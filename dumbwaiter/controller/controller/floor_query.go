@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/sim"
+)
+
+// floorQueryTimeout how long a floor has to answer where the car is
+const floorQueryTimeout = 2 * time.Second
+
+// newFloorQuery ask each floor service's status whether its AtFloor sensor sees the car
+func newFloorQuery(urls []string) controller.FloorQuery {
+	client := &http.Client{Timeout: floorQueryTimeout}
+	return func() (int, bool, error) {
+		var failures []string
+		for _, url := range urls {
+			status, err := getFloorStatus(client, strings.TrimSpace(url))
+			if err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			if status.AtFloor {
+				return status.FloorNum, true, nil
+			}
+		}
+		if len(failures) > 0 {
+			return 0, false, fmt.Errorf("the car wasn't seen, and some floors couldn't be asked: %s", strings.Join(failures, "; "))
+		}
+		return 0, false, nil
+	}
+}
+
+// getFloorStatus get a floor service's status
+func getFloorStatus(client *http.Client, url string) (*floor.Status, error) {
+	resp, err := client.Get(url + "/floor/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	status := &floor.Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("decoding %s's status: %w", url, err)
+	}
+	return status, nil
+}
+
+// newSimulatedFloorQuery read the simulated floors' AtFloor sensors
+func newSimulatedFloorQuery(shaft *sim.Shaft, numFloors int) controller.FloorQuery {
+	return func() (int, bool, error) {
+		for floorNum := 1; floorNum <= numFloors; floorNum++ {
+			atFloor, err := shaft.FloorRPi(floorNum).GetSignal(common.AtFloor)
+			if err != nil {
+				return 0, false, err
+			}
+			if atFloor {
+				return floorNum, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+}
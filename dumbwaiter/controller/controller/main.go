@@ -2,31 +2,135 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/common/httpservice"
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/drive"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/sim"
 )
 
 var (
-	httpAddrFlag = flag.String("http_addr", "localhost:9090", "host:port to serve http api on")
-	numFloors    = flag.Int("num_floors", 3, "the number of floors the dumbwaiter will serve")
+	httpAddrFlag  = flag.String("http_addr", "localhost:9090", "host:port to serve http api on")
+	numFloors     = flag.Int("num_floors", 3, "the number of floors the dumbwaiter will serve")
+	pinMapFile    = flag.String("pin_map", "", "json file mapping the controller's pins to gpio lines (default is the standard wiring)")
+	driveName     = flag.String("drive", drive.ThreeRelayOpenerName, "the hoist hardware: "+strings.Join(drive.Names(), ", "))
+	recordFile    = flag.String("record", "", "record the controller's gpio traffic to this file, for replaying later")
+	dispatchName  = flag.String("dispatch", controller.ScanPolicyName, "how the car's calls are served: "+strings.Join(controller.PolicyNames(), ", "))
+	priorityFloor = flag.Int("priority_floor", 0, "the floor the priority dispatch policy serves first")
+	refFloor      = flag.Int("reference_floor", 1, "the floor the car is homed towards when the controller starts without knowing where it is")
+	reverseDelay  = flag.Duration("min_reverse_delay", time.Second, "how long the stopped car rests before it is started the other way")
+	minRunTime    = flag.Duration("min_run_time", 500*time.Millisecond, "how long the started car runs before it can be stopped to reverse")
+	dwellTime     = flag.Duration("dwell_time", 5*time.Second, "how long the car stays at a floor it arrived at, for loading and unloading")
+	homeFloor     = flag.Int("home_floor", 0, "the floor the car returns to after it has been idle for -home_idle_time (0 for none)")
+	homeIdleTime  = flag.Duration("home_idle_time", time.Minute, "how long the car is idle before it returns to the home floor")
+	limitSwitches = flag.Bool("limit_switches", false, "the controller's RPi has TopLimit and BottomLimit switches wired past the end floors (their inputs read tripped when they aren't wired)")
+	floorURLs     = flag.String("floor_urls", "", "comma separated floor service urls, asked where the car is when the controller starts")
+	simulate      = flag.Bool("simulate", false, "run against a simulated shaft with in-process floor sensors instead of the gpio hardware")
 )
 
 // start the service.
 func main() {
 	// parse flags
 	flag.Parse()
+	if *numFloors < 2 {
+		log.Fatalf("a dumbwaiter needs at least 2 floors, got %d", *numFloors)
+	}
+	if *priorityFloor < 0 || *priorityFloor > *numFloors {
+		log.Fatalf("the priority floor must be between 0 (none) and %d, got %d", *numFloors, *priorityFloor)
+	}
+	if *refFloor < 1 || *refFloor > *numFloors {
+		log.Fatalf("the reference floor must be between 1 and %d, got %d", *numFloors, *refFloor)
+	}
+	if *homeFloor < 0 || *homeFloor > *numFloors {
+		log.Fatalf("the home floor must be between 0 (none) and %d, got %d", *numFloors, *homeFloor)
+	}
+	policy, err := controller.NewDispatchPolicy(*dispatchName, *priorityFloor)
+	if err != nil {
+		log.Fatalf("controller startup failed: %v", err)
+	}
+	options := controllerOptions{policy: policy, referenceFloor: *refFloor, limitSwitches: *limitSwitches, minReverseDelay: *reverseDelay, minRunTime: *minRunTime,
+		dwellTime: *dwellTime, homeFloor: *homeFloor, homeIdleTime: *homeIdleTime}
+	if *floorURLs != "" {
+		options.floorQuery = newFloorQuery(strings.Split(*floorURLs, ","))
+	}
+
+	if *simulate {
+		s, err := newSimulatedHTTPService(*httpAddrFlag, *numFloors, *driveName, options)
+		if err != nil {
+			log.Fatalf("simulated controller startup failed: %v", err)
+		}
+		s.RunService()
+		return
+	}
+
+	var piDevice common.LevelRPi
+	piDevice, err = newRPiDevice(*pinMapFile, *driveName, *limitSwitches)
+	if err != nil {
+		log.Fatalf("controller startup failed: %v", err)
+	}
+	if *recordFile != "" {
+		f, err := os.Create(*recordFile)
+		if err != nil {
+			log.Fatalf("controller startup failed: %v", err)
+		}
+		defer f.Close()
+		piDevice = common.NewRecordingRPi(piDevice, f)
+	}
+	motor, err := drive.New(*driveName, piDevice)
+	if err != nil {
+		log.Fatalf("controller startup failed: %v", err)
+	}
 
-	s := newControllerHTTPService(*httpAddrFlag, *numFloors) // create the controller with http nature
+	s, err := newControllerHTTPService(*httpAddrFlag, *numFloors, piDevice, motor, options) // create the controller with http nature
+	if err != nil {
+		log.Fatalf("controller startup failed: %v", err)
+	}
 
 	s.RunService() // start the controller listening for requests
 }
 
-func newControllerHTTPService(httpAddr string, numFloors int) *httpservice.Service {
-	// construct controller object and start its processing loop
-	controller := controller.NewController(numFloors)
-	controller.StartProcessingLoop()
+// newRPiDevice load the pin map and open just the gpio lines the drive (and the limit switches)
+// use, the opener's relays are pulsed like its wall buttons
+func newRPiDevice(pinMapFile string, driveName string, limitSwitches bool) (*common.PulsedRPi, error) {
+	pinMap := common.DefaultPinMap()
+	if pinMapFile != "" {
+		var err error
+		if pinMap, err = common.LoadPinMap(pinMapFile); err != nil {
+			return nil, err
+		}
+	}
+	requiredPins, err := drive.RequiredPins(driveName)
+	if err != nil {
+		return nil, err
+	}
+	if limitSwitches {
+		requiredPins = append(requiredPins, common.LimitPins...)
+	}
+	if err := pinMap.Validate(requiredPins...); err != nil {
+		return nil, err
+	}
+	piDevice := common.NewRPiDevice(pinMap.Only(requiredPins...))
+	if err := piDevice.Open(); err != nil {
+		return nil, err
+	}
+	return common.NewPulsedRPi(piDevice, common.DefaultOpenerPulseConfig), nil
+}
+
+func newControllerHTTPService(httpAddr string, numFloors int, piDevice common.RPi, motor drive.Drive, options controllerOptions) (*httpservice.Service, error) {
+	controller, err := startController(numFloors, piDevice, motor, options)
+	if err != nil {
+		return nil, err
+	}
 
 	// add the http endpoints
 	httpController := api.NewHTTPController(controller)
@@ -34,5 +138,72 @@ func newControllerHTTPService(httpAddr string, numFloors int) *httpservice.Servi
 	// add the final (common) http nature
 	s := httpservice.NewService(httpController, httpAddr, httpController.ServiceName)
 
-	return s
+	return s, nil
+}
+
+// controllerOptions the controller's settings from the flags
+type controllerOptions struct {
+	policy          controller.DispatchPolicy
+	referenceFloor  int
+	floorQuery      controller.FloorQuery // nil when the floors can't be asked
+	limitSwitches   bool                  // the controller's RPi has limit switches
+	minReverseDelay time.Duration
+	minRunTime      time.Duration
+	dwellTime       time.Duration
+	homeFloor       int // 0 for none
+	homeIdleTime    time.Duration
+}
+
+// startController construct controller object and start its processing loop
+func startController(numFloors int, piDevice common.RPi, motor drive.Drive, options controllerOptions) (*controller.Controller, error) {
+	controller := controller.NewController(numFloors).SetRPiDevice(piDevice).SetDrive(motor).SetDispatchPolicy(options.policy).
+		SetReferenceFloor(options.referenceFloor).SetFloorQuery(options.floorQuery).SetLimitSwitches(options.limitSwitches).
+		SetMinReverseDelay(options.minReverseDelay).SetMinRunTime(options.minRunTime).SetDwellTime(options.dwellTime).SetHomeFloor(options.homeFloor, options.homeIdleTime)
+	if homer, ok := motor.(drive.Homer); ok {
+		log.Info("homing the drive")
+		if err := homer.Home(); err != nil {
+			return nil, err
+		}
+	}
+	controller.StartProcessingLoop()
+	return controller, nil
+}
+
+// newSimulatedHTTPService run the controller against a simulated shaft, each floor's sensors
+// run in-process and call the controller directly.  The shaft's endpoints press the floors'
+// buttons and inject faults.
+func newSimulatedHTTPService(httpAddr string, numFloors int, driveName string, options controllerOptions) (*httpservice.Service, error) {
+	if driveName != drive.ThreeRelayOpenerName {
+		return nil, fmt.Errorf("the simulated shaft only has a %s drive", drive.ThreeRelayOpenerName)
+	}
+	config := sim.DefaultConfig
+	config.NumFloors = numFloors
+	shaft := sim.NewShaft(config)
+	go shaft.Run(make(chan struct{}))
+
+	piDevice := shaft.ControllerRPi()
+	options.floorQuery = newSimulatedFloorQuery(shaft, numFloors)
+	options.limitSwitches = config.OverTravel > 0
+	controller, err := startController(numFloors, piDevice, drive.NewThreeRelayOpener(piDevice), options)
+	if err != nil {
+		return nil, err
+	}
+	for floorNum := 1; floorNum <= numFloors; floorNum++ {
+		floor.NewSensors(floorNum, "").SetNumFloors(numFloors).SetRPiDevice(shaft.FloorRPi(floorNum)).SetControllerClient(api.NewInProcessController(controller)).StartProcessingLoop()
+	}
+
+	httpController := api.NewHTTPController(controller)
+	return httpservice.NewService(&simulatedService{controller: httpController, shaft: sim.NewHTTPShaft(shaft)}, httpAddr, httpController.ServiceName), nil
+}
+
+// simulatedService serves both the controller's and the simulated shaft's endpoints
+type simulatedService struct {
+	controller *api.HTTPController
+	shaft      *sim.HTTPShaft
+}
+
+// AddEndpoints adds the controller's and the shaft's http endpoints to the server
+func (s *simulatedService) AddEndpoints(router *mux.Router) {
+	s.controller.AddEndpoints(router)
+	s.shaft.AddEndpoints(router)
 }
@@ -1,12 +1,15 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/drive"
 )
 
 // TestRequestUpFromStop test requesting the car to move up 1 floor when it is stopped
@@ -56,10 +59,387 @@ func TestRequestDownFromMovingUp(t *testing.T) {
 func TestRequestStopFromMovingUp(t *testing.T) {
 	// setup
 	dwController := setup(t, 2, Up, []common.PiPin{common.OpenerStop})
+	dwController.SetDepartedFloor(2)
 
 	// test
-	dwController.SetStopRequested()                         // send the stop request
-	waitForStatus(t, 2, 2, Stopped, dwController, 3*time.Second) // verify that the dumbwaiter is now stopped
+	assert.NoError(t, dwController.EmergencyStop("floor3"))
+
+	// final validation: the car is held between floors rather than sent back to floor 2
+	time.Sleep(50 * time.Millisecond)
+	status := dwController.GetStatus()
+	assert.Equal(t, EmergencyStopped, status.State)
+	assert.Equal(t, Stopped, status.MovingDirection)
+	assert.Equal(t, 0, status.RequestedFloor)
+	assert.Equal(t, "floor3", status.EmergencyStop.Requester)
+	assert.Equal(t, 2, status.EmergencyStop.LastSeenFloor)
+	assert.True(t, status.EmergencyStop.BetweenFloors)
+	assert.True(t, errors.Is(dwController.SetRequestedFloor(1), ErrEmergencyStopped))
+
+	assert.NoError(t, dwController.ResetEmergencyStop())
+	assert.Equal(t, Idle, dwController.GetState())
+	assert.Nil(t, dwController.GetEmergencyStop())
+}
+
+// TestEmergencyStopInFaultAndMaintenance a stop made in fault or maintenance is latched, the
+// car comes back to it when the fault is reset or it is put back in service
+func TestEmergencyStopInFaultAndMaintenance(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerStop, common.OpenerStop, common.OpenerStop})
+	dwController := NewController(3).SetRPiDevice(mockRPi)
+	dwController.SetLastSeenFloor(2)
+	dwController.latchFault(FaultStall, "test fault")
+
+	// test
+	assert.NoError(t, dwController.EmergencyStop("floor1"))
+	assert.Equal(t, Fault, dwController.GetState())
+	assert.Equal(t, "floor1", dwController.GetEmergencyStop().Requester)
+	assert.NoError(t, dwController.ResetFault())
+	assert.Equal(t, EmergencyStopped, dwController.GetState())
+	assert.NoError(t, dwController.ResetEmergencyStop())
+	assert.Equal(t, Idle, dwController.GetState())
+
+	assert.NoError(t, dwController.SetMaintenance(true))
+	assert.NoError(t, dwController.EmergencyStop("floor3"))
+	assert.Equal(t, Maintenance, dwController.GetState())
+	assert.True(t, errors.Is(dwController.SetRequestedFloor(1), ErrEmergencyStopped))
+
+	// final validation
+	assert.NoError(t, dwController.SetMaintenance(false))
+	assert.Equal(t, EmergencyStopped, dwController.GetState())
+	assert.Equal(t, "floor3", dwController.GetEmergencyStop().Requester)
+}
+
+// TestDepartedFloor the car is at its last seen floor until it departs, and again when it
+// comes back
+func TestDepartedFloor(t *testing.T) {
+	dwController := NewController(3)
+	dwController.SetLastSeenFloor(2)
+	assert.True(t, dwController.GetStatus().AtFloor)
+
+	dwController.SetDepartedFloor(1) // a stale departure from another floor is ignored
+	assert.True(t, dwController.GetStatus().AtFloor)
+	dwController.SetDepartedFloor(2)
+	status := dwController.GetStatus()
+	assert.False(t, status.AtFloor)
+	assert.Equal(t, 2, status.LastSeenFloor)
+
+	dwController.SetLastSeenFloor(2)
+	assert.True(t, dwController.GetStatus().AtFloor)
+}
+
+// TestSingleButtonUpFromStop a single button opener that last closed needs one press to go up
+func TestSingleButtonUpFromStop(t *testing.T) {
+	// setup
+	dwController := setupSingleButton(t, []common.PiPin{common.OpenerButton})
+
+	// test
+	dwController.SetRequestedFloor(3)
+	waitForStatus(t, 2, 3, Up, dwController, 3*time.Second)
+}
+
+// TestSingleButtonDownFromStop a single button opener that last closed has to go through
+// up and stop to get to down
+func TestSingleButtonDownFromStop(t *testing.T) {
+	// setup
+	dwController := setupSingleButton(t, []common.PiPin{common.OpenerButton, common.OpenerButton, common.OpenerButton})
+
+	// test
+	dwController.SetRequestedFloor(1)
+	waitForStatus(t, 2, 1, Down, dwController, 3*time.Second)
+}
+
+// TestTravelObserverResync the drive is told which way the car is really travelling
+func TestTravelObserverResync(t *testing.T) {
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerButton})
+	opener := drive.NewSingleButtonOpener(mockRPi)
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetDrive(opener)
+	dwController.SetLastSeenFloor(2)
+	opener.Up()
+	dwController.SetMovingDirection(Up)
+
+	dwController.SetLastSeenFloor(1) // the car is really going down
+
+	assert.Equal(t, Down, dwController.GetMovingDirection())
+}
+
+// fakeFloorMover a drive that goes to floors by itself and reports the floors it reaches
+type fakeFloorMover struct {
+	moves   chan int
+	onFloor func(floor int)
+}
+
+func (f *fakeFloorMover) Up() error   { return nil }
+func (f *fakeFloorMover) Down() error { return nil }
+func (f *fakeFloorMover) Stop() error { return nil }
+func (f *fakeFloorMover) MoveToFloor(floor int) error {
+	f.moves <- floor
+	return nil
+}
+func (f *fakeFloorMover) OnFloor(onFloor func(floor int)) {
+	f.onFloor = onFloor
+}
+
+// TestFloorMoverDrive a drive that can go to floors is sent to the requested floor, and the
+// floors it reports are the last seen floor
+func TestFloorMoverDrive(t *testing.T) {
+	// setup
+	mover := &fakeFloorMover{moves: make(chan int, 1)}
+	dwController := NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", nil)).SetDrive(mover).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(1)
+	dwController.SetRequestedFloor(1)
+	dwController.StartProcessingLoop()
+
+	// test
+	dwController.SetRequestedFloor(3)
+	assert.Equal(t, 3, <-mover.moves)
+	mover.onFloor(2)
+	mover.onFloor(3)
+	waitForStatus(t, 3, 3, Stopped, dwController, 3*time.Second)
+}
+
+// TestCallsServedInElevatorOrder with calls above and below, the car keeps going up to the
+// call above, then comes back down for the other
+func TestCallsServedInElevatorOrder(t *testing.T) {
+	// setup
+	mover := &fakeFloorMover{moves: make(chan int, 1)}
+	dwController := NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", nil)).SetDrive(mover).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	assert.NoError(t, dwController.AddCall(1, "floor1"))
+	assert.NoError(t, dwController.AddCall(3, "floor3"))
+	assert.Equal(t, 3, dwController.GetRequestedFloor())
+	dwController.StartProcessingLoop()
+
+	// test
+	assert.Equal(t, 3, <-mover.moves)
+	mover.onFloor(3)
+	assert.Equal(t, 1, <-mover.moves)
+	calls := dwController.GetStatus().PendingCalls
+	assert.Len(t, calls, 1)
+	assert.Equal(t, 1, calls[0].Floor)
+	assert.Equal(t, "floor1", calls[0].Requester)
+	mover.onFloor(2)
+	mover.onFloor(1)
+	waitForStatus(t, 1, 1, Stopped, dwController, 3*time.Second)
+	assert.Empty(t, dwController.GetCalls())
+}
+
+// TestCancelCall a cancelled call is no longer served
+func TestCancelCall(t *testing.T) {
+	dwController := NewController(3)
+	dwController.SetLastSeenFloor(2)
+	dwController.AddCall(3, "floor3")
+	dwController.AddCall(1, "floor1")
+	assert.Equal(t, 3, dwController.GetRequestedFloor())
+
+	assert.NoError(t, dwController.CancelCall(3))
+	assert.Equal(t, 1, dwController.GetRequestedFloor())
+	assert.True(t, errors.Is(dwController.CancelCall(3), ErrNoCall))
+	assert.True(t, errors.Is(dwController.CancelCall(4), ErrInvalidFloor))
+	assert.Len(t, dwController.GetCalls(), 1)
+}
+
+// TestStoppingBeforeReversing the car going up is stopped before it goes down, and the
+// states it goes through are in the history
+func TestStoppingBeforeReversing(t *testing.T) {
+	// setup
+	dwController := setup(t, 2, Up, []common.PiPin{common.OpenerStop, common.OpenerDown})
+
+	// test
+	dwController.SetRequestedFloor(1)
+	waitForStatus(t, 2, 1, Down, dwController, 3*time.Second)
+
+	// final validation
+	status := dwController.GetStatus()
+	assert.Equal(t, MovingDown, status.State)
+	var changes []string
+	for _, change := range status.StateHistory {
+		changes = append(changes, fmt.Sprintf("%s -> %s", change.From, change.To))
+	}
+	assert.Equal(t, []string{"idle -> moving up", "moving up -> stopping", "stopping -> moving down"}, changes)
+}
+
+// TestReverseTiming a car called back the way it came runs for the minimum run time before it
+// is stopped, then rests for the minimum reverse delay before it is started the other way
+func TestReverseTiming(t *testing.T) {
+	// setup
+	clock := common.NewFakeClock(time.Now())
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop, common.OpenerDown})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetClock(clock).SetMinRunTime(time.Second).SetMinReverseDelay(2 * time.Second).
+		SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(3)
+	waitForState(t, MovingUp, dwController)
+	dwController.SetDepartedFloor(2)
+
+	// test
+	dwController.AddCall(1, "floor1")
+	assert.NoError(t, dwController.CancelCall(3))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, MovingUp, dwController.GetState(), "the car runs for the minimum run time")
+	assert.True(t, dwController.GetStatus().WaitingToReverse)
+
+	clock.Advance(time.Second)
+	waitForState(t, Stopping, dwController)
+	clock.Advance(1500 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, Stopping, dwController.GetState(), "the car rests for the minimum reverse delay")
+	assert.True(t, dwController.GetStatus().WaitingToReverse)
+
+	clock.Advance(500 * time.Millisecond)
+	waitForState(t, MovingDown, dwController)
+
+	// final validation
+	status := dwController.GetStatus()
+	assert.False(t, status.WaitingToReverse)
+	history := status.StateHistory
+	assert.Len(t, history, 3)
+	assert.Equal(t, time.Second, history[1].Time.Sub(history[0].Time), "moving up to stopping")
+	assert.Equal(t, 2*time.Second, history[2].Time.Sub(history[1].Time), "stopping to moving down")
+}
+
+// TestMaintenance a moving car can't be taken out of service, a car out of service doesn't
+// serve its calls until it is back in service
+func TestMaintenance(t *testing.T) {
+	// setup
+	dwController := setup(t, 2, Up, []common.PiPin{common.OpenerStop, common.OpenerUp})
+	assert.True(t, errors.Is(dwController.SetMaintenance(true), ErrIllegalTransition))
+	dwController.SetRequestedFloor(2)
+	waitForStatus(t, 2, 2, Stopped, dwController, 3*time.Second)
+
+	// test
+	assert.NoError(t, dwController.SetMaintenance(true))
+	dwController.SetRequestedFloor(3)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, Maintenance, dwController.GetState())
+	assert.Equal(t, Stopped, dwController.GetMovingDirection())
+	assert.NoError(t, dwController.SetMaintenance(false))
+	waitForStatus(t, 2, 3, Up, dwController, 3*time.Second)
+}
+
+// TestMaintenanceWhileStopping a car stopped away from its requested floor can be taken out
+// of service
+func TestMaintenanceWhileStopping(t *testing.T) {
+	dwController := NewController(3)
+	dwController.SetLastSeenFloor(2)
+	dwController.SetMovingDirection(Up)
+	dwController.SetMovingDirection(Stopped)
+	assert.Equal(t, Stopping, dwController.GetState())
+
+	assert.NoError(t, dwController.SetMaintenance(true))
+	assert.Equal(t, Maintenance, dwController.GetState())
+}
+
+// TestStateHistoryBounded only the latest state changes are kept
+func TestStateHistoryBounded(t *testing.T) {
+	dwController := NewController(3)
+	for i := 0; i < stateHistorySize; i++ {
+		dwController.SetMaintenance(true)
+		dwController.SetMaintenance(false)
+	}
+	history := dwController.GetStateHistory()
+	assert.Len(t, history, stateHistorySize)
+	assert.Equal(t, Idle, history[len(history)-1].To)
+}
+
+// TestStallFault a car that doesn't reach the next floor in time is stopped, and stays
+// stopped until the fault is reset
+func TestStallFault(t *testing.T) {
+	// setup
+	clock := common.NewFakeClock(time.Now())
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop, common.OpenerUp})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetClock(clock).SetTimeToMoveOneFloor(20 * time.Second).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(1)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(3)
+	waitForState(t, MovingUp, dwController)
+
+	// test
+	clock.Advance(15 * time.Second)
+	dwController.SetDepartedFloor(1)
+	dwController.SetLastSeenFloor(2) // reaching a floor restarts the time limit
+	clock.Advance(15 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, MovingUp, dwController.GetState())
+	clock.Advance(10 * time.Second)
+	waitForState(t, Fault, dwController)
+
+	// final validation
+	fault := dwController.GetStatus().Fault
+	assert.Equal(t, FaultStall, fault.Reason)
+	assert.True(t, errors.Is(dwController.SetRequestedFloor(1), ErrControllerFault))
+	clock.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, Fault, dwController.GetState())
+
+	assert.NoError(t, dwController.ResetFault())
+	assert.Nil(t, dwController.GetFault())
+	waitForState(t, MovingUp, dwController)
+}
+
+// TestOverrunFault a car that reaches the floor past its requested floor is stopped
+func TestOverrunFault(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(1)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(2)
+	waitForState(t, MovingUp, dwController)
+
+	// test
+	dwController.SetDepartedFloor(1)
+	dwController.SetLastSeenFloor(3) // floor 2's sensor didn't see the car
+
+	// final validation
+	waitForState(t, Fault, dwController)
+	assert.Equal(t, FaultOverrun, dwController.GetFault().Reason)
+	assert.Equal(t, Stopped, dwController.GetMovingDirection())
+}
+
+// TestMaintenanceInFault a car taken out of service in fault goes back to the fault when it
+// is put back in service, it serves its call once the fault is reset
+func TestMaintenanceInFault(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop, common.OpenerDown})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(1)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(2)
+	waitForState(t, MovingUp, dwController)
+	dwController.SetDepartedFloor(1)
+	dwController.SetLastSeenFloor(3)
+	waitForState(t, Fault, dwController)
+
+	// test
+	assert.NoError(t, dwController.SetMaintenance(true))
+	assert.Equal(t, Maintenance, dwController.GetState())
+	assert.True(t, errors.Is(dwController.SetRequestedFloor(1), ErrControllerFault))
+	assert.NoError(t, dwController.SetMaintenance(false))
+
+	// final validation
+	assert.Equal(t, Fault, dwController.GetState())
+	assert.Equal(t, FaultOverrun, dwController.GetFault().Reason)
+	assert.NoError(t, dwController.ResetFault())
+	waitForState(t, MovingDown, dwController) // back to the call to floor 2
+}
+
+// waitForState wait for the controller to get to state
+func waitForState(t *testing.T, state State, dwc *Controller) {
+	for i := 0; i < 100 && dwc.GetState() != state; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, state, dwc.GetState())
+}
+
+// setupSingleButton creates a single button controller stopped at floor 2
+func setupSingleButton(t *testing.T, expectedSendSignals []common.PiPin) *Controller {
+	mockRPi := common.NewMockRPi(t, "controllerRPi", expectedSendSignals)
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetDrive(drive.NewSingleButtonOpener(mockRPi)).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.SetRequestedFloor(2)
+	dwController.StartProcessingLoop()
+	return dwController
 }
 
 // setup creates a controller, with last seen floor = 2 and mock pi interface
@@ -0,0 +1,231 @@
+package controller
+
+/*
+dispatch.go decides where the car goes next.  A DispatchPolicy picks the pending call to
+serve from a snapshot of the car and its calls, and returns the drive command that takes the
+car there.  The policies don't keep state or start goroutines, so they can be tested on their
+own.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DriveCommand what the controller should tell the drive to do
+type DriveCommand int
+
+// DriveCommand constants are NoCommand (leave the drive as it is), UpCommand, DownCommand and StopCommand.
+const (
+	NoCommand DriveCommand = iota
+	UpCommand
+	DownCommand
+	StopCommand
+)
+
+func (d DriveCommand) String() string {
+	return [...]string{"none", "up", "down", "stop"}[d]
+}
+
+// CarState a snapshot of the car and its pending calls
+type CarState struct {
+	LastSeenFloor   int
+	AtFloor         bool      // the car is still at LastSeenFloor
+	MovingDirection Direction // the way the car is moving, or Stopped
+	TravelDirection Direction // the way the car last moved
+	RequestedFloor  int       // the floor the car is going to, 0 when nothing has been requested
+	Calls           []Call    // the pending calls, in the order they were made
+	TopLimit        bool      // the car has tripped the limit switch past the top floor
+	BottomLimit     bool      // the car has tripped the limit switch past the bottom floor
+}
+
+// Dispatch a policy's decision
+type Dispatch struct {
+	Floor   int          // the floor the car should go to (the requested floor), 0 for none
+	Command DriveCommand // the command that moves the car towards Floor
+}
+
+// DispatchPolicy picks the call the car serves next
+type DispatchPolicy interface {
+	Next(state CarState) Dispatch
+}
+
+// dispatch policy names used to choose a policy in the controller's configuration
+const (
+	ScanPolicyName     = "scan"
+	FIFOPolicyName     = "fifo"
+	NearestPolicyName  = "nearest"
+	PriorityPolicyName = "priority"
+)
+
+// PolicyNames the names of the policies that can be built with NewDispatchPolicy
+func PolicyNames() []string {
+	names := []string{ScanPolicyName, FIFOPolicyName, NearestPolicyName, PriorityPolicyName}
+	sort.Strings(names)
+	return names
+}
+
+// NewDispatchPolicy build the named policy, priorityFloor is only used by the priority policy
+// (which serves the other calls in SCAN order)
+func NewDispatchPolicy(name string, priorityFloor int) (DispatchPolicy, error) {
+	switch name {
+	case ScanPolicyName:
+		return ScanPolicy{}, nil
+	case FIFOPolicyName:
+		return FIFOPolicy{}, nil
+	case NearestPolicyName:
+		return NearestPolicy{}, nil
+	case PriorityPolicyName:
+		if priorityFloor < 1 {
+			return nil, fmt.Errorf("the %s policy needs a priority floor", PriorityPolicyName)
+		}
+		return PriorityFloorPolicy{Floor: priorityFloor, Fallback: ScanPolicy{}}, nil
+	}
+	return nil, fmt.Errorf("unknown dispatch policy %q, expected one of %s", name, strings.Join(PolicyNames(), ", "))
+}
+
+// ScanPolicy serve the calls in elevator order: keep going the way the car is travelling while
+// there are calls ahead of it, then reverse
+type ScanPolicy struct{}
+
+// Next the nearest call ahead of the car, or when there are none the nearest call behind it
+func (ScanPolicy) Next(state CarState) Dispatch {
+	floor, ok := scanNext(state.Calls, state.LastSeenFloor, state.AtFloor, state.TravelDirection)
+	if !ok {
+		floor = state.RequestedFloor
+	}
+	return commandToward(state, floor)
+}
+
+// FIFOPolicy serve the calls in the order they were made
+type FIFOPolicy struct{}
+
+// Next the oldest call
+func (FIFOPolicy) Next(state CarState) Dispatch {
+	floor := state.RequestedFloor
+	if len(state.Calls) > 0 {
+		floor = state.Calls[0].Floor
+	}
+	return commandToward(state, floor)
+}
+
+// NearestPolicy serve the call closest to the car first, the oldest of equally close calls
+type NearestPolicy struct{}
+
+// Next the call closest to the car
+func (NearestPolicy) Next(state CarState) Dispatch {
+	floor, nearest := state.RequestedFloor, -1
+	// positions in half floors, a car that has left its last seen floor is half way to the next
+	position := 2 * state.LastSeenFloor
+	if !state.AtFloor {
+		if state.TravelDirection == Down {
+			position--
+		} else {
+			position++
+		}
+	}
+	for _, call := range state.Calls {
+		distance := 2*call.Floor - position
+		if distance < 0 {
+			distance = -distance
+		}
+		if nearest < 0 || distance < nearest {
+			floor, nearest = call.Floor, distance
+		}
+	}
+	return commandToward(state, floor)
+}
+
+// PriorityFloorPolicy serve a call from the priority floor before any other, the other calls
+// are served by the fallback policy
+type PriorityFloorPolicy struct {
+	Floor    int
+	Fallback DispatchPolicy
+}
+
+// Next the priority floor when it has a call, otherwise the fallback's choice
+func (p PriorityFloorPolicy) Next(state CarState) Dispatch {
+	for _, call := range state.Calls {
+		if call.Floor == p.Floor {
+			return commandToward(state, p.Floor)
+		}
+	}
+	return p.Fallback.Next(state)
+}
+
+// commandToward the command that gets the car moving towards floor: a stationary car is
+// started in floor's direction, a car moving the wrong way is stopped first (it is started
+// the right way by a later command) and a car at floor is stopped.  A car stopped after it
+// departed floor is sent back the way it came.
+func commandToward(state CarState, floor int) Dispatch {
+	dispatch := Dispatch{Floor: floor, Command: NoCommand}
+	switch {
+	case floor == 0:
+		// nothing has been requested yet
+	case floor > state.LastSeenFloor:
+		if state.MovingDirection == Stopped {
+			dispatch.Command = UpCommand
+		} else if state.MovingDirection == Down {
+			dispatch.Command = StopCommand
+		}
+	case floor < state.LastSeenFloor:
+		if state.MovingDirection == Stopped {
+			dispatch.Command = DownCommand
+		} else if state.MovingDirection == Up {
+			dispatch.Command = StopCommand
+		}
+	case state.MovingDirection != Stopped:
+		dispatch.Command = StopCommand
+	case !state.AtFloor:
+		// stopped between floors, e.g. by an emergency stop
+		dispatch.Command = UpCommand
+		if state.TravelDirection == Up {
+			dispatch.Command = DownCommand
+		}
+	}
+	return dispatch
+}
+
+// scanNext the floor to serve next in SCAN order: the nearest call ahead of the car in its
+// travel direction, or when there are none the nearest call the other way.  A call at
+// lastSeenFloor is ahead only while the car is still at that floor.  False when there are
+// no calls.
+func scanNext(calls []Call, lastSeenFloor int, atFloor bool, travel Direction) (int, bool) {
+	if len(calls) == 0 {
+		return 0, false
+	}
+	if floor, ok := nearestAhead(calls, lastSeenFloor, atFloor, travel); ok {
+		return floor, true
+	}
+	reverse := Down
+	if travel == Down {
+		reverse = Up
+	}
+	if floor, ok := nearestAhead(calls, lastSeenFloor, atFloor, reverse); ok {
+		return floor, true
+	}
+	// the only call is at the floor the car has just left
+	return calls[0].Floor, true
+}
+
+// nearestAhead the nearest call from lastSeenFloor going in direction
+func nearestAhead(calls []Call, lastSeenFloor int, atFloor bool, direction Direction) (int, bool) {
+	nearest, found := 0, false
+	for _, call := range calls {
+		distance := call.Floor - lastSeenFloor
+		if direction == Down {
+			distance = -distance
+		}
+		if distance < 0 || (distance == 0 && !atFloor) {
+			continue
+		}
+		if !found || distance < nearest {
+			nearest, found = distance, true
+		}
+	}
+	if direction == Down {
+		nearest = -nearest
+	}
+	return lastSeenFloor + nearest, found
+}
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// calls make a pending call for each floor, oldest first
+func calls(floors ...int) []Call {
+	var calls []Call
+	for _, floor := range floors {
+		calls = append(calls, Call{Floor: floor})
+	}
+	return calls
+}
+
+// TestScanNext calls ahead of the car are served before the car reverses
+func TestScanNext(t *testing.T) {
+	tests := []struct {
+		name          string
+		calls         []int
+		lastSeenFloor int
+		atFloor       bool
+		travel        Direction
+		expected      int
+	}{
+		{"nearest ahead going up", []int{5, 1, 3}, 2, true, Up, 3},
+		{"nearest ahead going down", []int{5, 1, 3}, 4, true, Down, 3},
+		{"reverse when nothing ahead", []int{1, 2}, 3, true, Up, 2},
+		{"call at the floor the car is at", []int{3, 2}, 2, true, Down, 2},
+		{"call at the floor the car has left is behind it", []int{2, 1}, 2, false, Up, 1},
+		{"only call at the floor the car has left", []int{2}, 2, false, Up, 2},
+	}
+	for _, test := range tests {
+		floor, ok := scanNext(calls(test.calls...), test.lastSeenFloor, test.atFloor, test.travel)
+		assert.True(t, ok, test.name)
+		assert.Equal(t, test.expected, floor, test.name)
+	}
+
+	_, ok := scanNext(nil, 2, true, Up)
+	assert.False(t, ok)
+}
+
+// TestPolicies each policy's pick from the same calls
+func TestPolicies(t *testing.T) {
+	// the car is stopped at floor 3 after going up, floors 2, 5 and 1 have called in that order
+	state := CarState{LastSeenFloor: 3, AtFloor: true, MovingDirection: Stopped, TravelDirection: Up, RequestedFloor: 3, Calls: calls(2, 5, 1)}
+	tests := []struct {
+		name     string
+		policy   DispatchPolicy
+		expected Dispatch
+	}{
+		{"scan keeps going up", ScanPolicy{}, Dispatch{Floor: 5, Command: UpCommand}},
+		{"fifo serves the oldest", FIFOPolicy{}, Dispatch{Floor: 2, Command: DownCommand}},
+		{"nearest serves the closest", NearestPolicy{}, Dispatch{Floor: 2, Command: DownCommand}},
+		{"priority floor first", PriorityFloorPolicy{Floor: 1, Fallback: ScanPolicy{}}, Dispatch{Floor: 1, Command: DownCommand}},
+		{"priority floor without a call", PriorityFloorPolicy{Floor: 4, Fallback: ScanPolicy{}}, Dispatch{Floor: 5, Command: UpCommand}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.policy.Next(state), test.name)
+	}
+}
+
+// TestNearestPolicyBetweenFloors a car that has left its floor is closer to the floor ahead
+func TestNearestPolicyBetweenFloors(t *testing.T) {
+	state := CarState{LastSeenFloor: 2, AtFloor: false, MovingDirection: Up, TravelDirection: Up, RequestedFloor: 3, Calls: calls(1, 3)}
+	assert.Equal(t, Dispatch{Floor: 3, Command: NoCommand}, NearestPolicy{}.Next(state))
+}
+
+// TestPoliciesWithoutCalls without calls the car carries on to (or stays at) the requested
+// floor, and stays put when nothing has been requested
+func TestPoliciesWithoutCalls(t *testing.T) {
+	for _, policy := range []DispatchPolicy{ScanPolicy{}, FIFOPolicy{}, NearestPolicy{}, PriorityFloorPolicy{Floor: 1, Fallback: ScanPolicy{}}} {
+		state := CarState{LastSeenFloor: 2, AtFloor: true, MovingDirection: Up, TravelDirection: Up, RequestedFloor: 2}
+		assert.Equal(t, Dispatch{Floor: 2, Command: StopCommand}, policy.Next(state))
+		state = CarState{LastSeenFloor: 2, AtFloor: true, MovingDirection: Stopped, TravelDirection: Up}
+		assert.Equal(t, Dispatch{Floor: 0, Command: NoCommand}, policy.Next(state))
+	}
+}
+
+// TestCommandToward a car moving the wrong way is stopped before it is reversed
+func TestCommandToward(t *testing.T) {
+	tests := []struct {
+		moving   Direction
+		floor    int
+		expected DriveCommand
+	}{
+		{Stopped, 3, UpCommand},
+		{Up, 3, NoCommand},
+		{Down, 3, StopCommand},
+		{Stopped, 1, DownCommand},
+		{Down, 1, NoCommand},
+		{Up, 1, StopCommand},
+		{Up, 2, StopCommand},
+		{Stopped, 2, NoCommand},
+	}
+	for _, test := range tests {
+		state := CarState{LastSeenFloor: 2, AtFloor: true, MovingDirection: test.moving}
+		assert.Equal(t, test.expected, commandToward(state, test.floor).Command, "moving %s to floor %d", test.moving, test.floor)
+	}
+
+	// a car stopped after leaving floor 2 goes back to it
+	between := CarState{LastSeenFloor: 2, AtFloor: false, MovingDirection: Stopped, TravelDirection: Up}
+	assert.Equal(t, DownCommand, commandToward(between, 2).Command)
+	between.TravelDirection = Down
+	assert.Equal(t, UpCommand, commandToward(between, 2).Command)
+}
+
+// TestNewDispatchPolicy policies are built by name
+func TestNewDispatchPolicy(t *testing.T) {
+	policy, err := NewDispatchPolicy(PriorityPolicyName, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, PriorityFloorPolicy{Floor: 2, Fallback: ScanPolicy{}}, policy)
+	_, err = NewDispatchPolicy(PriorityPolicyName, 0)
+	assert.Error(t, err)
+	_, err = NewDispatchPolicy("elevator", 0)
+	assert.Error(t, err)
+	for _, name := range []string{ScanPolicyName, FIFOPolicyName, NearestPolicyName} {
+		_, err := NewDispatchPolicy(name, 0)
+		assert.NoError(t, err, name)
+	}
+}
@@ -0,0 +1,39 @@
+package controller
+
+/*
+dwell.go holds the car at the floor it has arrived at for the dwell time, so it can be loaded
+and unloaded, and sends a car that has been idle for a while back to its home floor.
+*/
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// HomeRequester the requester of the calls that return an idle car to its home floor
+const HomeRequester = "home"
+
+// isDwelling whether the car is still dwelling at the floor it arrived at, calls are held
+// until it is done
+func (c *Controller) isDwelling() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.state == Dwelling && c.clock.Now().Before(c.dwellUntil)
+}
+
+// returnHome call an idle car back to the home floor once it has been idle for the home idle
+// time, nothing when there is no home floor or the car is already there
+func (c *Controller) returnHome() {
+	if c.homeFloor == 0 || (c.GetLastSeenFloor() == c.homeFloor && c.IsAtFloor()) {
+		return
+	}
+	c.stateMu.RLock()
+	idleFor := c.clock.Now().Sub(c.idleSince)
+	c.stateMu.RUnlock()
+	if idleFor < c.homeIdleTime {
+		return
+	}
+	log.Infof("controller returning the car to home floor %d after %s idle", c.homeFloor, idleFor)
+	if err := c.AddCall(c.homeFloor, HomeRequester); err != nil {
+		log.Errorf("controller couldn't return the car to its home floor: %v", err)
+	}
+}
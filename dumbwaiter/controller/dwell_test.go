@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// TestDwellHoldsCalls a car that arrives at its floor stays there for the dwell time, a call
+// made meanwhile is taken but held until the dwell is up
+func TestDwellHoldsCalls(t *testing.T) {
+	// setup
+	clock := common.NewFakeClock(time.Now())
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop, common.OpenerDown})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetClock(clock).SetDwellTime(5 * time.Second).SetMinReverseDelay(0).
+		SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(3)
+	waitForState(t, MovingUp, dwController)
+	dwController.SetLastSeenFloor(3)
+	waitForState(t, Dwelling, dwController)
+
+	// test
+	assert.NoError(t, dwController.AddCall(1, "floor1"))
+	clock.Advance(4 * time.Second)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, Dwelling, dwController.GetState(), "the call is held while the car dwells")
+
+	clock.Advance(time.Second)
+	waitForState(t, MovingDown, dwController)
+
+	// final validation
+	assert.Equal(t, 5*time.Second, dwController.GetStatus().Config.DwellTime)
+}
+
+// TestReturnToHomeFloor a car left idle away from its home floor is called back there once it
+// has been idle for the home idle time
+func TestReturnToHomeFloor(t *testing.T) {
+	// setup
+	clock := common.NewFakeClock(time.Now())
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerDown})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetClock(clock).SetHomeFloor(1, time.Minute).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.StartProcessingLoop()
+
+	// test
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, Idle, dwController.GetState())
+	clock.Advance(time.Minute)
+	waitForState(t, MovingDown, dwController)
+
+	// final validation
+	status := dwController.GetStatus()
+	assert.Equal(t, 1, status.RequestedFloor)
+	assert.Equal(t, []Call{{Floor: 1, Requester: HomeRequester, Time: clock.Now()}}, status.PendingCalls)
+	assert.Equal(t, Config{HomeFloor: 1, HomeIdleTime: time.Minute, MinReverseDelay: defaultMinReverseDelay, MinRunTime: defaultMinRunTime}, status.Config)
+}
+
+// TestInvalidHomeFloor a home floor the controller doesn't have turns the return home off
+func TestInvalidHomeFloor(t *testing.T) {
+	dwController := NewController(3).SetHomeFloor(4, time.Minute)
+	assert.Equal(t, 0, dwController.GetConfig().HomeFloor)
+	assert.Equal(t, 2, NewController(3).SetHomeFloor(2, time.Minute).GetConfig().HomeFloor)
+}
@@ -0,0 +1,21 @@
+package controller
+
+import "errors"
+
+// the errors callers can act on, match them with errors.Is
+var (
+	// ErrInvalidFloor the floor isn't one of the dumbwaiter's floors, retrying won't help
+	ErrInvalidFloor = errors.New("invalid floor")
+	// ErrControllerFault the controller is in a fault state and won't move the car until it is cleared
+	ErrControllerFault = errors.New("controller in fault")
+	// ErrControllerUnreachable the controller could not be reached, the request may work later
+	ErrControllerUnreachable = errors.New("controller unreachable")
+	// ErrIllegalTransition the controller can't change to the requested state from the state it is in
+	ErrIllegalTransition = errors.New("illegal state transition")
+	// ErrPositionUnknown the controller is still looking for the car, the request may work later
+	ErrPositionUnknown = errors.New("car position unknown")
+	// ErrEmergencyStopped the car has been emergency stopped and won't take calls until the stop is reset
+	ErrEmergencyStopped = errors.New("controller emergency stopped")
+	// ErrNoCall the floor has no pending call to cancel
+	ErrNoCall = errors.New("no pending call")
+)
@@ -0,0 +1,119 @@
+package controller
+
+/*
+estop.go is the emergency stop.  A floor's stop button cuts the drive wherever the car is,
+between floors included, and drops the pending calls.  The stop is latched: calls are refused
+until it is reset through the api, and a car in fault or maintenance comes back to it.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EmergencyStopStatus the latched emergency stop
+type EmergencyStopStatus struct {
+	Requester     string // who stopped the car, e.g. floor2, empty when the caller didn't say
+	Time          time.Time
+	LastSeenFloor int  // the floor the car was last seen at
+	BetweenFloors bool // the car was stopped after it had departed LastSeenFloor
+}
+
+// GetEmergencyStop get the latched emergency stop, nil when the car hasn't been stopped
+func (c *Controller) GetEmergencyStop() *EmergencyStopStatus {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	if c.emergencyStop == nil {
+		return nil
+	}
+	emergencyStop := *c.emergencyStop
+	return &emergencyStop
+}
+
+// SetStopRequested get a stop request from a caller that didn't say who it is
+func (c *Controller) SetStopRequested() error {
+	return c.EmergencyStop("")
+}
+
+// EmergencyStop cut the drive where the car is for requester, drop the pending calls and
+// refuse new ones until the stop is reset.  A controller in fault or maintenance already
+// holds the car, it keeps its state but the stop is latched for when it leaves it.  The first
+// stop is kept until it is reset.
+func (c *Controller) EmergencyStop(requester string) error {
+	who := requester
+	if who == "" {
+		who = "an unnamed requester"
+	}
+	lastSeenFloor, atFloor := c.GetLastSeenFloor(), c.IsAtFloor()
+	log.Warnf("controller emergency stop from %s, the car was last seen at floor %d (at the floor: %t)", who, lastSeenFloor, atFloor)
+
+	// the stop is latched with the state change, before the drive is cut, so neither a reset
+	// nor the processing loop can come between
+	car := c.carState()
+	c.stateMu.Lock()
+	state, reason := c.state, fmt.Sprintf("emergency stop from %s", who)
+	switch state {
+	case Fault, Maintenance, EmergencyStopped:
+		log.Warnf("controller already holding the car in %s", state)
+	default:
+		if err := c.changeStateLocked(EmergencyStopped, reason, car); err != nil {
+			c.stateMu.Unlock()
+			return err
+		}
+		if state == Homing {
+			c.homing.Phase, c.homing.Direction, c.homing.Error = HomingFailed, Stopped, reason
+		}
+	}
+	if c.emergencyStop == nil {
+		c.emergencyStop = &EmergencyStopStatus{Requester: requester, Time: c.clock.Now(), LastSeenFloor: lastSeenFloor, BetweenFloors: !atFloor}
+	}
+	c.stateMu.Unlock()
+
+	c.requestedFloorMU.Lock()
+	c.calls.clear()
+	c.requestedFloor = 0
+	c.requestedFloorMU.Unlock()
+
+	err := c.cut()
+	if state == Homing {
+		c.restoreSpeed()
+	}
+	if err != nil {
+		return fmt.Errorf("cutting the drive: %w", err)
+	}
+	return nil
+}
+
+// ResetEmergencyStop clear the latched emergency stop, the car takes calls again.  A
+// controller in fault or maintenance stays there.
+func (c *Controller) ResetEmergencyStop() error {
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state == EmergencyStopped {
+		if err := c.changeStateLocked(Idle, "emergency stop reset", car); err != nil {
+			return err
+		}
+	}
+	if c.emergencyStop != nil {
+		log.Infof("controller emergency stop from %q reset", c.emergencyStop.Requester)
+		c.emergencyStop = nil
+	}
+	return nil
+}
+
+// checkEmergencyStop ErrEmergencyStopped while the emergency stop is latched, in fault and
+// maintenance too
+func (c *Controller) checkEmergencyStop() error {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	switch {
+	case c.emergencyStop != nil:
+		return fmt.Errorf("%w: by %q at %s", ErrEmergencyStopped, c.emergencyStop.Requester, c.emergencyStop.Time.Format(time.RFC3339))
+	case c.state == EmergencyStopped:
+		return ErrEmergencyStopped
+	}
+	return nil
+}
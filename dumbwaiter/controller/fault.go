@@ -0,0 +1,137 @@
+package controller
+
+/*
+fault.go detects a car that isn't moving the way it was told to.  A fault stops the drive
+and is latched: the car won't move again until the fault is reset.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultTimeToMoveOneFloor how long the car has to reach the next floor once it is moving
+const defaultTimeToMoveOneFloor = 20 * time.Second
+
+// FaultReason the reason code for a fault
+type FaultReason string
+
+// fault reason codes
+const (
+	FaultStall   FaultReason = "stall"   // the car didn't reach a floor in time, e.g. the opener jammed
+	FaultOverrun FaultReason = "overrun" // the car went past its requested floor, e.g. the floor's AtFloor sensor failed
+
+	FaultOverTravel FaultReason = "over_travel" // the car tripped a limit switch past the top or bottom floor
+)
+
+// FaultStatus the latched fault
+type FaultStatus struct {
+	Reason  FaultReason
+	Message string
+	Time    time.Time
+}
+
+// GetFault get the latched fault, nil when the controller isn't in fault
+func (c *Controller) GetFault() *FaultStatus {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	if c.fault == nil {
+		return nil
+	}
+	fault := *c.fault
+	return &fault
+}
+
+// ResetFault clear the latched fault, the car serves its calls again unless an emergency
+// stop was latched meanwhile
+func (c *Controller) ResetFault() error {
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state != Fault {
+		return nil
+	}
+	to, reason := Idle, "fault reset"
+	if c.emergencyStop != nil {
+		to, reason = EmergencyStopped, "fault reset, the emergency stop isn't reset"
+	}
+	if err := c.changeStateLocked(to, reason, car); err != nil {
+		return err
+	}
+	if c.fault != nil {
+		log.Infof("controller fault reset: %s", c.fault.Message)
+	}
+	c.fault = nil
+	return nil
+}
+
+// checkFault ErrControllerFault when the controller is in fault, or holds a fault that
+// hasn't been reset
+func (c *Controller) checkFault() error {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	switch {
+	case c.fault != nil:
+		return fmt.Errorf("%w: %s, %s", ErrControllerFault, c.fault.Reason, c.fault.Message)
+	case c.state == Fault:
+		return ErrControllerFault
+	}
+	return nil
+}
+
+// latchFault stop the drive and hold the controller in fault, the first fault is kept until
+// it is reset.  The fault is recorded with the state change so a reset can't come between.
+func (c *Controller) latchFault(reason FaultReason, message string) {
+	if c.GetState() == Fault {
+		return
+	}
+	log.Errorf("controller fault %s: %s", reason, message)
+	if err := c.cut(); err != nil {
+		log.Errorf("controller cutting the drive for the fault failed: %v", err)
+	}
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if c.state == Fault {
+		return
+	}
+	if err := c.changeStateLocked(Fault, fmt.Sprintf("%s: %s", reason, message), car); err != nil {
+		return
+	}
+	c.fault = &FaultStatus{Reason: reason, Message: message, Time: c.clock.Now()}
+}
+
+// checkTravelTime latch a stall when the moving car hasn't reached a floor in time (a car
+// being homed at a slower speed gets longer), true when the car stalled
+func (c *Controller) checkTravelTime() bool {
+	c.stateMu.RLock()
+	state, direction, movingFor := c.state, c.movingDirectionLocked(), c.clock.Now().Sub(c.movingSince)
+	limit := c.timeToMoveOneFloor
+	if state == Homing && c.homing != nil {
+		limit = time.Duration(float64(limit) / c.homing.Speed)
+	}
+	c.stateMu.RUnlock()
+	if direction == Stopped || movingFor <= limit {
+		return false
+	}
+	message := fmt.Sprintf("the car %s moving %s hasn't reached a floor in %s", state, direction, movingFor)
+	if state == Homing {
+		c.failHoming(FaultStall, message)
+	} else {
+		c.latchFault(FaultStall, message)
+	}
+	return true
+}
+
+// checkOverrun latch an overrun when the car reached floor from lastSeenFloor without seeing
+// requestedFloor, which is between them
+func (c *Controller) checkOverrun(lastSeenFloor int, floor int, requestedFloor int) {
+	if lastSeenFloor == 0 || requestedFloor == 0 {
+		return
+	}
+	if (lastSeenFloor < requestedFloor && requestedFloor < floor) || (floor < requestedFloor && requestedFloor < lastSeenFloor) {
+		c.latchFault(FaultOverrun, fmt.Sprintf("the car went from floor %d to %d past its requested floor %d", lastSeenFloor, floor, requestedFloor))
+	}
+}
@@ -0,0 +1,184 @@
+package controller
+
+/*
+homing.go finds the car when the controller starts without knowing where it is.  The floors
+(or a drive that tracks the car) are asked first, when none of them sees the car it is moved
+slowly towards the reference floor until a floor reports it.  Calls are refused until the
+car has been found.
+*/
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/drive"
+)
+
+// homingSpeed the fraction of full speed the car is homed at, on drives that can run slower
+const homingSpeed = 0.25
+
+// HomingPhase how far homing has got
+type HomingPhase string
+
+// homing phases
+const (
+	HomingQuerying HomingPhase = "querying" // asking the floors whether the car is at one of them
+	HomingMoving   HomingPhase = "moving"   // moving the car slowly towards the reference floor
+	HomingDone     HomingPhase = "done"     // a floor has seen the car
+	HomingFailed   HomingPhase = "failed"   // the car wasn't found, the controller is in fault
+)
+
+// HomingStatus the progress of the latest homing
+type HomingStatus struct {
+	Phase          HomingPhase
+	ReferenceFloor int       // the floor the car is moved towards
+	Direction      Direction // the way the car is moved, Stopped until it is moving
+	Speed          float64   // the fraction of full speed the car is moved at
+	Started        time.Time
+	Floor          int    `json:",omitempty"` // the floor the car was found at
+	Error          string `json:",omitempty"` // why homing failed
+}
+
+// FloorQuery ask the floors where the car is, found is false when none of them sees it
+type FloorQuery func() (floor int, found bool, err error)
+
+// GetHoming get the latest homing's progress, nil when the controller hasn't had to home
+func (c *Controller) GetHoming() *HomingStatus {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	if c.homing == nil {
+		return nil
+	}
+	homing := *c.homing
+	return &homing
+}
+
+// checkPositionKnown ErrPositionUnknown while the car is being homed
+func (c *Controller) checkPositionKnown() error {
+	if c.GetState() == Homing {
+		return fmt.Errorf("%w: the car is being homed", ErrPositionUnknown)
+	}
+	return nil
+}
+
+// startHoming start looking for the car, the homing status is set with the state so it is
+// there for anything that sees the controller homing
+func (c *Controller) startHoming() {
+	car := c.carState()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if err := c.changeStateLocked(Homing, "the car's position is unknown", car); err != nil {
+		return
+	}
+	log.Infof("controller homing the car towards floor %d", c.referenceFloor)
+	c.homing = &HomingStatus{Phase: HomingQuerying, ReferenceFloor: c.referenceFloor, Direction: Stopped, Speed: 1, Started: c.clock.Now()}
+}
+
+// home take homing a step further: finish it once a floor has seen the car, otherwise ask
+// the floors, then start the car moving
+func (c *Controller) home() {
+	if floor := c.GetLastSeenFloor(); floor != 0 {
+		c.finishHoming(floor)
+		return
+	}
+	if c.GetHoming().Phase != HomingQuerying {
+		return // moving, the floors report the car when it gets to one
+	}
+	if floor, found := c.queryPosition(); found {
+		c.SetLastSeenFloor(floor)
+		c.finishHoming(floor)
+		return
+	}
+	c.startHomingMove()
+}
+
+// queryPosition ask the floors, and a drive that tracks the car, where the car is
+func (c *Controller) queryPosition() (int, bool) {
+	if positioner, ok := c.motor.(drive.Positioner); ok {
+		if position, known := positioner.Position(); known && math.Abs(position-math.Round(position)) < 0.05 {
+			return int(math.Round(position)), true
+		}
+	}
+	if c.floorQuery == nil {
+		return 0, false
+	}
+	floor, found, err := c.floorQuery()
+	if err != nil {
+		log.Warnf("controller couldn't ask the floors where the car is: %v", err)
+		return 0, false
+	}
+	if found && c.checkFloor(floor) != nil {
+		log.Warnf("controller ignoring the car being seen at floor %d", floor)
+		return 0, false
+	}
+	return floor, found
+}
+
+// startHomingMove move the car slowly towards the reference floor's end of the shaft, the
+// first floor it reaches is where it is
+func (c *Controller) startHomingMove() {
+	direction, move := Down, c.motor.Down
+	if c.referenceFloor == c.topFloor {
+		direction, move = Up, c.motor.Up
+	}
+	// a car against a limit switch is past the end floor, it is found by moving it back
+	if top, bottom := c.getLimits(); direction == Up && top {
+		direction, move = Down, c.motor.Down
+	} else if direction == Down && bottom {
+		direction, move = Up, c.motor.Up
+	}
+	speed := 1.0
+	if setter, ok := c.motor.(drive.SpeedSetter); ok {
+		if err := setter.SetSpeed(homingSpeed); err != nil {
+			log.Warnf("controller homing at full speed, setting the speed failed: %v", err)
+		} else {
+			speed = homingSpeed
+		}
+	}
+	log.Infof("controller homing: moving the car %s at %.0f%% speed", direction, speed*100)
+	if err := move(); err != nil {
+		log.Errorf("controller homing move failed, will retry: %v", err)
+		return
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.homing.Phase, c.homing.Direction, c.homing.Speed = HomingMoving, direction, speed
+	c.movingSince = c.clock.Now()
+}
+
+// finishHoming the car has been found at floor, stop it when it was being moved
+func (c *Controller) finishHoming(floor int) {
+	if c.GetHoming().Phase == HomingMoving {
+		if err := c.motor.Stop(); err != nil {
+			log.Errorf("controller stopping the homed car failed, will retry: %v", err)
+			return
+		}
+		c.restoreSpeed()
+	}
+	c.stateMu.Lock()
+	c.homing.Phase, c.homing.Direction, c.homing.Floor = HomingDone, Stopped, floor
+	c.stateMu.Unlock()
+	c.changeState(Idle, fmt.Sprintf("the car was found at floor %d", floor))
+}
+
+// failHoming homing has taken too long or the car went past the end of the shaft, the car
+// is stopped and the controller faults with reason
+func (c *Controller) failHoming(reason FaultReason, message string) {
+	c.stateMu.Lock()
+	c.homing.Phase, c.homing.Direction, c.homing.Error = HomingFailed, Stopped, message
+	c.stateMu.Unlock()
+	c.latchFault(reason, message)
+	c.restoreSpeed()
+}
+
+// restoreSpeed put a drive that was slowed down for homing back to full speed
+func (c *Controller) restoreSpeed() {
+	if setter, ok := c.motor.(drive.SpeedSetter); ok {
+		if err := setter.SetSpeed(1); err != nil {
+			log.Errorf("controller restoring full speed after homing failed: %v", err)
+		}
+	}
+}
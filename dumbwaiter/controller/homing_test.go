@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// TestHomingMovesTowardsReferenceFloor a controller that doesn't know where the car is moves
+// it towards the reference floor until a floor sees it, calls are refused until then
+func TestHomingMovesTowardsReferenceFloor(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerDown, common.OpenerStop})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetLoopFrequency(10 * time.Millisecond)
+	dwController.StartProcessingLoop()
+
+	// test
+	waitForHomingPhase(t, HomingMoving, dwController)
+	status := dwController.GetStatus()
+	assert.Equal(t, Homing, status.State)
+	assert.Equal(t, Down, status.MovingDirection)
+	assert.Equal(t, 1, status.Homing.ReferenceFloor)
+	assert.True(t, errors.Is(dwController.SetRequestedFloor(3), ErrPositionUnknown))
+
+	dwController.SetLastSeenFloor(2)
+
+	// final validation
+	waitForState(t, Idle, dwController)
+	homing := dwController.GetHoming()
+	assert.Equal(t, HomingDone, homing.Phase)
+	assert.Equal(t, 2, homing.Floor)
+	assert.Equal(t, Stopped, dwController.GetMovingDirection())
+}
+
+// TestHomingFromFloorQuery a floor that already sees the car saves moving it
+func TestHomingFromFloorQuery(t *testing.T) {
+	dwController := NewController(3).SetRPiDevice(common.NewMockRPi(t, "controllerRPi", nil)).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetFloorQuery(func() (int, bool, error) { return 3, true, nil })
+	dwController.StartProcessingLoop()
+
+	waitForHomingPhase(t, HomingDone, dwController)
+	waitForState(t, Idle, dwController)
+	assert.Equal(t, 3, dwController.GetLastSeenFloor())
+	assert.NoError(t, dwController.SetRequestedFloor(3))
+}
+
+// TestHomingFailure a car that isn't found in time is stopped and the controller faults
+func TestHomingFailure(t *testing.T) {
+	// setup
+	clock := common.NewFakeClock(time.Now())
+	mockRPi := common.NewMockRPi(t, "controllerRPi", []common.PiPin{common.OpenerUp, common.OpenerStop})
+	dwController := NewController(3).SetRPiDevice(mockRPi).SetClock(clock).SetReferenceFloor(3).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetFloorQuery(func() (int, bool, error) { return 0, false, errors.New("floors unreachable") })
+	dwController.StartProcessingLoop()
+	waitForHomingPhase(t, HomingMoving, dwController)
+	assert.Equal(t, Up, dwController.GetMovingDirection())
+
+	// test
+	clock.Advance(defaultTimeToMoveOneFloor + time.Second)
+
+	// final validation
+	waitForState(t, Fault, dwController)
+	homing := dwController.GetHoming()
+	assert.Equal(t, HomingFailed, homing.Phase)
+	assert.Contains(t, homing.Error, "hasn't reached a floor")
+	assert.Equal(t, FaultStall, dwController.GetFault().Reason)
+}
+
+// waitForHomingPhase wait for homing to get to phase
+func waitForHomingPhase(t *testing.T, phase HomingPhase, dwc *Controller) {
+	for i := 0; i < 100; i++ {
+		if homing := dwc.GetHoming(); homing != nil && homing.Phase == phase {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Fail(t, "homing didn't get to "+string(phase), "%+v", dwc.GetHoming())
+}
@@ -0,0 +1,100 @@
+package controller
+
+/*
+limits.go protects the ends of the shaft.  Limit switches past the top and bottom floors trip
+when the car over-travels: the drive is cut as soon as one trips, the controller faults and
+the car won't be sent any further towards a tripped switch.
+*/
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// startLimitSwitches watch the limit switches, they are polled every loop when the RPi can't
+// watch its inputs
+func (c *Controller) startLimitSwitches() {
+	var watches []<-chan common.SignalEvent
+	for _, pin := range common.LimitPins {
+		watch, err := c.piDevice.WatchSignal(pin)
+		if err != nil {
+			if !errors.Is(err, common.ErrWatchNotSupported) {
+				log.Warnf("controller polling the limit switches, watching %s failed: %v", pin, err)
+			}
+			c.limitsPolled = true
+			break
+		}
+		watches = append(watches, watch)
+	}
+	c.pollLimits() // pick up a switch that was already tripped
+	if c.limitsPolled {
+		return
+	}
+	for _, watch := range watches {
+		go func(watch <-chan common.SignalEvent) {
+			for event := range watch {
+				c.setLimit(event.Pin, event.Value())
+			}
+		}(watch)
+	}
+}
+
+// pollLimits read the limit switches
+func (c *Controller) pollLimits() {
+	for _, pin := range common.LimitPins {
+		tripped, err := c.piDevice.GetSignal(pin)
+		if err != nil {
+			log.Errorf("controller reading the %s switch failed: %v", pin, err)
+			continue
+		}
+		c.setLimit(pin, tripped)
+	}
+}
+
+// setLimit record a limit switch's state, the drive is cut and the controller faults when
+// it trips under a moving car.  A stopped car is only kept from moving towards it.
+func (c *Controller) setLimit(pin common.PiPin, tripped bool) {
+	c.stateMu.Lock()
+	limit := &c.topLimit
+	if pin == common.BottomLimit {
+		limit = &c.bottomLimit
+	}
+	prior := *limit
+	*limit = tripped
+	state, direction := c.state, c.movingDirectionLocked()
+	c.stateMu.Unlock()
+
+	if !tripped || prior {
+		if prior && !tripped {
+			log.Infof("controller %s switch cleared", pin)
+		}
+		return
+	}
+	message := fmt.Sprintf("the %s switch tripped, the car was last seen at floor %d", pin, c.GetLastSeenFloor())
+	if direction == Stopped && state != Fault {
+		log.Warnf("controller %s with the car stopped", message)
+		return
+	}
+	switch state {
+	case Fault:
+		log.Errorf("controller %s", message)
+		if err := c.cut(); err != nil {
+			log.Errorf("controller cutting the drive failed: %v", err)
+		}
+	case Homing:
+		c.failHoming(FaultOverTravel, message)
+	default:
+		c.latchFault(FaultOverTravel, message) // stops the drive
+	}
+}
+
+// getLimits whether the top and bottom limit switches are tripped
+func (c *Controller) getLimits() (top bool, bottom bool) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.topLimit, c.bottomLimit
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// limitRPi a mock RPi whose limit switches are tripped by the test, they can be watched
+// unless polled is set
+type limitRPi struct {
+	*common.MockRPi
+	polled   bool
+	tripped  map[common.PiPin]bool
+	watchers map[common.PiPin]chan common.SignalEvent
+	mu       sync.Mutex
+}
+
+func newLimitRPi(t *testing.T, polled bool, expectedCalls []common.PiPin) *limitRPi {
+	return &limitRPi{MockRPi: common.NewMockRPi(t, "controllerRPi", expectedCalls), polled: polled,
+		tripped: map[common.PiPin]bool{}, watchers: map[common.PiPin]chan common.SignalEvent{}}
+}
+
+// GetSignal the limit switches, the other pins are the mock's
+func (r *limitRPi) GetSignal(pin common.PiPin) (bool, error) {
+	if pin != common.TopLimit && pin != common.BottomLimit {
+		return r.MockRPi.GetSignal(pin)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tripped[pin], nil
+}
+
+// WatchSignal watch a limit switch
+func (r *limitRPi) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	if r.polled {
+		return nil, common.ErrWatchNotSupported
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers[pin] = make(chan common.SignalEvent, 10)
+	return r.watchers[pin], nil
+}
+
+// setLimit trip or clear a limit switch
+func (r *limitRPi) setLimit(pin common.PiPin, tripped bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tripped[pin] = tripped
+	if watcher, ok := r.watchers[pin]; ok {
+		edge := common.Falling
+		if tripped {
+			edge = common.Rising
+		}
+		watcher <- common.SignalEvent{Pin: pin, Edge: edge, Time: time.Now()}
+	}
+}
+
+// TestLimitSwitchCutsDrive a car that trips the top limit is stopped and faults, once reset it
+// can only be sent back down
+func TestLimitSwitchCutsDrive(t *testing.T) {
+	// setup
+	rpi := newLimitRPi(t, false, []common.PiPin{common.OpenerUp, common.OpenerStop, common.OpenerDown})
+	dwController := NewController(3).SetRPiDevice(rpi).SetLimitSwitches(true).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(3)
+	waitForState(t, MovingUp, dwController)
+
+	// test
+	dwController.SetDepartedFloor(2)
+	rpi.setLimit(common.TopLimit, true) // floor 3's sensor didn't see the car
+
+	// final validation
+	waitForState(t, Fault, dwController)
+	assert.Equal(t, FaultOverTravel, dwController.GetFault().Reason)
+	assert.Equal(t, Stopped, dwController.GetMovingDirection())
+	assert.True(t, dwController.GetStatus().TopLimit)
+
+	assert.NoError(t, dwController.ResetFault())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, Idle, dwController.GetState(), "the car isn't sent up past the top limit")
+
+	assert.NoError(t, dwController.CancelCall(3))
+	assert.NoError(t, dwController.SetRequestedFloor(1))
+	waitForState(t, MovingDown, dwController)
+	rpi.setLimit(common.TopLimit, false)
+	for i := 0; i < 100 && dwController.GetStatus().TopLimit; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, dwController.GetStatus().TopLimit)
+}
+
+// cuttingDrive a drive that counts its stops and cuts
+type cuttingDrive struct {
+	stops, cuts int
+	mu          sync.Mutex
+}
+
+func (d *cuttingDrive) Up() error   { return nil }
+func (d *cuttingDrive) Down() error { return nil }
+
+func (d *cuttingDrive) Stop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stops++
+	return nil
+}
+
+func (d *cuttingDrive) Cut() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cuts++
+	return nil
+}
+
+func (d *cuttingDrive) get() (stops int, cuts int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stops, d.cuts
+}
+
+// TestLimitSwitchHardCut a drive that can cut is cut, not soft stopped, when a limit trips
+func TestLimitSwitchHardCut(t *testing.T) {
+	// setup
+	rpi := newLimitRPi(t, false, nil)
+	motor := &cuttingDrive{}
+	dwController := NewController(3).SetRPiDevice(rpi).SetDrive(motor).SetLimitSwitches(true).SetLoopFrequency(10 * time.Millisecond)
+	dwController.SetLastSeenFloor(2)
+	dwController.StartProcessingLoop()
+	dwController.SetRequestedFloor(3)
+	waitForState(t, MovingUp, dwController)
+
+	// test
+	dwController.SetDepartedFloor(2)
+	rpi.setLimit(common.TopLimit, true)
+
+	// final validation
+	waitForState(t, Fault, dwController)
+	stops, cuts := motor.get()
+	assert.Equal(t, 0, stops)
+	assert.Equal(t, 1, cuts)
+}
+
+// TestLimitSwitchPolledWhileHoming a car found against the bottom limit is homed up, away from
+// it, when the limits can only be polled
+func TestLimitSwitchPolledWhileHoming(t *testing.T) {
+	// setup
+	rpi := newLimitRPi(t, true, []common.PiPin{common.OpenerUp, common.OpenerStop})
+	rpi.setLimit(common.BottomLimit, true)
+	dwController := NewController(3).SetRPiDevice(rpi).SetLimitSwitches(true).SetLoopFrequency(10 * time.Millisecond)
+	dwController.StartProcessingLoop()
+
+	// test
+	waitForHomingPhase(t, HomingMoving, dwController)
+	assert.Equal(t, Up, dwController.GetMovingDirection())
+	rpi.setLimit(common.BottomLimit, false)
+	dwController.SetLastSeenFloor(1)
+
+	// final validation
+	waitForState(t, Idle, dwController)
+	assert.Equal(t, HomingDone, dwController.GetHoming().Phase)
+	assert.False(t, dwController.GetStatus().BottomLimit)
+}
@@ -0,0 +1,60 @@
+package controller
+
+/*
+queue.go keeps the floors' pending calls, the controller's DispatchPolicy picks the one
+to serve next
+*/
+
+import "time"
+
+// Call a request for the car to come to a floor
+type Call struct {
+	Floor     int
+	Requester string    // who made the call, e.g. floor2, empty when the caller didn't say
+	Time      time.Time // when the call was made
+}
+
+// callQueue the pending calls, at most one per floor, in the order they were made
+type callQueue struct {
+	calls []Call
+}
+
+// add queue call, false when its floor already has a call (the first call is kept)
+func (q *callQueue) add(call Call) bool {
+	if q.has(call.Floor) {
+		return false
+	}
+	q.calls = append(q.calls, call)
+	return true
+}
+
+// remove the call for floor, false when there wasn't one
+func (q *callQueue) remove(floor int) bool {
+	for i, call := range q.calls {
+		if call.Floor == floor {
+			q.calls = append(q.calls[:i], q.calls[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// has whether floor has a pending call
+func (q *callQueue) has(floor int) bool {
+	for _, call := range q.calls {
+		if call.Floor == floor {
+			return true
+		}
+	}
+	return false
+}
+
+// list a copy of the pending calls
+func (q *callQueue) list() []Call {
+	return append([]Call{}, q.calls...)
+}
+
+// clear drop all the pending calls
+func (q *callQueue) clear() {
+	q.calls = nil
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCallQueue a floor has at most one pending call, the first one made
+func TestCallQueue(t *testing.T) {
+	var q callQueue
+	assert.True(t, q.add(Call{Floor: 3, Requester: "floor3"}))
+	assert.True(t, q.add(Call{Floor: 1, Requester: "floor1"}))
+	assert.False(t, q.add(Call{Floor: 3, Requester: "webapp"}))
+	assert.Equal(t, []Call{{Floor: 3, Requester: "floor3"}, {Floor: 1, Requester: "floor1"}}, q.list())
+
+	assert.True(t, q.remove(3))
+	assert.False(t, q.remove(3))
+	assert.Equal(t, []Call{{Floor: 1, Requester: "floor1"}}, q.list())
+}
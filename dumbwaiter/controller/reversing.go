@@ -0,0 +1,51 @@
+package controller
+
+/*
+reversing.go spares the drive from being thrown from one direction straight into the other.
+A car that is stopped to reverse has run for at least the minimum run time, and it rests for
+the minimum reverse delay before it is started the other way.
+*/
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// the default reversing limits, garage door opener motors want a moment to stop turning
+const (
+	defaultMinReverseDelay = time.Second
+	defaultMinRunTime      = 500 * time.Millisecond
+)
+
+// IsWaitingToReverse whether the car is held back from reversing, by the minimum run time or
+// the minimum reverse delay
+func (c *Controller) IsWaitingToReverse() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+	return c.waitingToReverse
+}
+
+// waitToReverse whether dispatch has to wait because it would reverse the car too soon: a
+// car being stopped to reverse hasn't run for the minimum run time, or a stopped car being
+// started the other way hasn't rested for the minimum reverse delay.  A car arriving at its
+// requested floor is always stopped.
+func (c *Controller) waitToReverse(dispatch Dispatch) bool {
+	arriving := c.GetLastSeenFloor() == dispatch.Floor && c.IsAtFloor()
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	now, wait := c.clock.Now(), false
+	switch dispatch.Command {
+	case StopCommand:
+		wait = !arriving && now.Sub(c.startedAt) < c.minRunTime
+	case UpCommand:
+		wait = c.travelDirection == Down && now.Sub(c.stoppedAt) < c.minReverseDelay
+	case DownCommand:
+		wait = c.travelDirection == Up && now.Sub(c.stoppedAt) < c.minReverseDelay
+	}
+	if wait && !c.waitingToReverse {
+		log.Infof("controller waiting to reverse before the %s command", dispatch.Command)
+	}
+	c.waitingToReverse = wait
+	return wait
+}
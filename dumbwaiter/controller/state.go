@@ -0,0 +1,123 @@
+package controller
+
+/*
+state.go defines the controller's states and the transitions allowed between them.  The
+car's moving direction comes from the state, and every change of state is kept in a bounded
+history.
+*/
+
+import (
+	"fmt"
+	"time"
+)
+
+// stateHistorySize the number of state changes kept in the history
+const stateHistorySize = 50
+
+// State the controller's state
+type State int
+
+// State constants, the car only moves in MovingUp and MovingDown
+const (
+	Idle             State = iota // stopped, with no call to serve
+	MovingUp                      // the drive is taking the car up
+	MovingDown                    // the drive is taking the car down
+	Stopping                      // the car was stopped away from its requested floor, e.g. before reversing
+	Dwelling                      // the car was stopped at its requested floor
+	Fault                         // the car won't move until the fault is cleared
+	Maintenance                   // the car has been taken out of service
+	Homing                        // looking for the car, its position is unknown
+	EmergencyStopped              // the car was stopped where it was, it won't move until the stop is reset
+)
+
+func (s State) String() string {
+	return [...]string{"idle", "moving up", "moving down", "stopping", "dwelling", "fault", "maintenance", "homing", "emergency stopped"}[s]
+}
+
+// direction the way the car moves in the state
+func (s State) direction() Direction {
+	switch s {
+	case MovingUp:
+		return Up
+	case MovingDown:
+		return Down
+	}
+	return Stopped
+}
+
+// StateChange a transition in the state history
+type StateChange struct {
+	Time   time.Time
+	From   State
+	To     State
+	Reason string // what caused the change
+}
+
+// guard reports why a transition can't happen with the car as it is, nil when it can
+type guard func(car CarState, topFloor int) error
+
+// transitions the allowed transitions, with the guards that have to pass for them
+var transitions = map[State]map[State]guard{
+	Idle:             {MovingUp: notAtTopFloor, MovingDown: notAtBottomFloor, Fault: nil, Maintenance: nil, Homing: nil, EmergencyStopped: nil},
+	MovingUp:         {Stopping: nil, Dwelling: atAFloor, Fault: nil, EmergencyStopped: nil},
+	MovingDown:       {Stopping: nil, Dwelling: atAFloor, Fault: nil, EmergencyStopped: nil},
+	Stopping:         {Idle: nil, MovingUp: notAtTopFloor, MovingDown: notAtBottomFloor, Fault: nil, Maintenance: nil, EmergencyStopped: nil},
+	Dwelling:         {Idle: nil, MovingUp: notAtTopFloor, MovingDown: notAtBottomFloor, Fault: nil, Maintenance: nil, EmergencyStopped: nil},
+	Fault:            {Idle: nil, Maintenance: nil, EmergencyStopped: nil},
+	Maintenance:      {Idle: nil, Fault: nil, EmergencyStopped: nil},
+	Homing:           {Idle: nil, Fault: nil, Maintenance: stopped, EmergencyStopped: nil},
+	EmergencyStopped: {Idle: nil},
+}
+
+// checkTransition nil when the table allows from to to and its guard passes, otherwise an
+// ErrIllegalTransition saying why not
+func checkTransition(from State, to State, car CarState, topFloor int) error {
+	check, ok := transitions[from][to]
+	if !ok {
+		return fmt.Errorf("%w: %s to %s", ErrIllegalTransition, from, to)
+	}
+	if check != nil {
+		if err := check(car, topFloor); err != nil {
+			return fmt.Errorf("%w: %s to %s, %v", ErrIllegalTransition, from, to, err)
+		}
+	}
+	return nil
+}
+
+// notAtTopFloor the car can't go up from the top floor, or past the top limit switch
+func notAtTopFloor(car CarState, topFloor int) error {
+	if car.TopLimit {
+		return fmt.Errorf("the top limit switch is tripped")
+	}
+	if car.AtFloor && car.LastSeenFloor == topFloor {
+		return fmt.Errorf("the car is at the top floor")
+	}
+	return nil
+}
+
+// notAtBottomFloor the car can't go down from the bottom floor, or past the bottom limit switch
+func notAtBottomFloor(car CarState, topFloor int) error {
+	if car.BottomLimit {
+		return fmt.Errorf("the bottom limit switch is tripped")
+	}
+	if car.AtFloor && car.LastSeenFloor == 1 {
+		return fmt.Errorf("the car is at the bottom floor")
+	}
+	return nil
+}
+
+// stopped the car has to be stopped first
+func stopped(car CarState, topFloor int) error {
+	if car.MovingDirection != Stopped {
+		return fmt.Errorf("the car is moving %s", car.MovingDirection)
+	}
+	return nil
+}
+
+// atAFloor the car only dwells at a floor
+func atAFloor(car CarState, topFloor int) error {
+	if !car.AtFloor {
+		return fmt.Errorf("the car isn't at a floor")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckTransition the table's transitions are allowed when their guards pass, the rest
+// are illegal
+func TestCheckTransition(t *testing.T) {
+	atTop := CarState{LastSeenFloor: 3, AtFloor: true}
+	atBottom := CarState{LastSeenFloor: 1, AtFloor: true}
+	between := CarState{LastSeenFloor: 2, AtFloor: false}
+	pastTop := CarState{LastSeenFloor: 3, AtFloor: false, TopLimit: true}
+	pastBottom := CarState{LastSeenFloor: 1, AtFloor: false, BottomLimit: true}
+	tests := []struct {
+		from  State
+		to    State
+		car   CarState
+		legal bool
+	}{
+		{Idle, MovingUp, atBottom, true},
+		{Idle, MovingUp, atTop, false},
+		{Idle, MovingDown, atBottom, false},
+		{Stopping, MovingDown, atTop, true},
+		{MovingUp, Dwelling, atTop, true},
+		{MovingUp, Dwelling, between, false},
+		{MovingUp, Stopping, between, true},
+		{MovingUp, MovingDown, between, false},
+		{MovingUp, Maintenance, between, false},
+		{Dwelling, Maintenance, atTop, true},
+		{Stopping, Maintenance, between, true},
+		{Fault, MovingUp, atBottom, false},
+		{Fault, Idle, between, true},
+		{Maintenance, MovingDown, atTop, false},
+		{Fault, EmergencyStopped, between, true},
+		{Maintenance, EmergencyStopped, atTop, true},
+		{EmergencyStopped, Fault, between, false},
+		{Idle, MovingUp, pastTop, false},
+		{Idle, MovingDown, pastTop, true},
+		{Stopping, MovingDown, pastBottom, false},
+		{Dwelling, MovingUp, pastBottom, true},
+	}
+	for _, test := range tests {
+		err := checkTransition(test.from, test.to, test.car, 3)
+		if test.legal {
+			assert.NoError(t, err, "%s to %s", test.from, test.to)
+		} else {
+			assert.True(t, errors.Is(err, ErrIllegalTransition), "%s to %s", test.from, test.to)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package drive
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// ReversingContactorPins the pins a reversing contactor pair is wired to
+var ReversingContactorPins = []common.PiPin{common.ContactorUp, common.ContactorDown}
+
+// DefaultContactorConfig suits common din rail contactors
+var DefaultContactorConfig = ContactorConfig{InterlockDelay: 100 * time.Millisecond}
+
+// ContactorConfig timing of a reversing contactor pair
+type ContactorConfig struct {
+	InterlockDelay time.Duration // time for one contactor to drop out before the other may pull in
+}
+
+// ReversingContactor drives a mains motor through a relay board with an up and a down
+// contactor.  The contactors are held in while the car moves, and one is always released
+// (and given time to drop out) before the other is energized, after a stop too.
+type ReversingContactor struct {
+	piDevice  common.LevelRPi
+	clock     common.Clock
+	config    ContactorConfig
+	energized common.PiPin // the contactor that is pulled in, or was last
+	running   bool         // true when energized is pulled in
+	released  time.Time    // when energized was released, zero when it never was
+	mu        sync.Mutex
+}
+
+// NewReversingContactor create a drive that switches the contactors through piDevice
+func NewReversingContactor(piDevice common.LevelRPi, config ContactorConfig) *ReversingContactor {
+	return &ReversingContactor{piDevice: piDevice, clock: common.RealClock, config: config}
+}
+
+// Up energize the up contactor
+func (r *ReversingContactor) Up() error {
+	return r.energize(common.ContactorUp)
+}
+
+// Down energize the down contactor
+func (r *ReversingContactor) Down() error {
+	return r.energize(common.ContactorDown)
+}
+
+// Stop release both contactors
+func (r *ReversingContactor) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.releaseAll()
+}
+
+func (r *ReversingContactor) energize(pin common.PiPin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running && r.energized == pin {
+		return nil
+	}
+	if r.running {
+		log.Infof("reversing, releasing %s before energizing %s", r.energized, pin)
+		if err := r.releaseAll(); err != nil {
+			return err
+		}
+	}
+	if r.energized != pin && !r.released.IsZero() {
+		if wait := r.released.Add(r.config.InterlockDelay).Sub(r.clock.Now()); wait > 0 {
+			log.Debugf("waiting %s for %s to drop out before energizing %s", wait, r.energized, pin)
+			r.clock.Sleep(wait)
+		}
+	}
+	if err := r.piDevice.SetSignal(pin, true); err != nil {
+		r.releaseAll()
+		return fmt.Errorf("energizing %s: %w", pin, err)
+	}
+	r.energized = pin
+	r.running = true
+	return nil
+}
+
+// releaseAll release both contactors, the caller must hold mu
+func (r *ReversingContactor) releaseAll() error {
+	var firstErr error
+	for _, pin := range ReversingContactorPins {
+		if err := r.piDevice.SetSignal(pin, false); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("releasing %s: %w", pin, err)
+		}
+	}
+	if firstErr == nil {
+		if r.running {
+			r.released = r.clock.Now()
+		}
+		r.running = false
+	}
+	return firstErr
+}
+
+// ReversingContactor constructor setters for builder pattern
+
+// SetClock used by testing to control time
+func (r *ReversingContactor) SetClock(clock common.Clock) *ReversingContactor {
+	r.clock = clock
+	return r
+}
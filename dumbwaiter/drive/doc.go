@@ -0,0 +1,2 @@
+//Package drive drive package implements the motors that move the dumbwaiter's car, each behind the Drive interface the controller calls.
+package drive
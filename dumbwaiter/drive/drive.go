@@ -0,0 +1,101 @@
+package drive
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// Drive the interface functions for moving the dumbwaiter's car
+type Drive interface {
+	Up() error   // start the car moving up
+	Down() error // start the car moving down
+	Stop() error // stop the car
+}
+
+// Cutter implemented by drives whose Stop is soft (it ramps, decelerates or waits its turn)
+// and that can also cut the motor at once, for faults and emergencies
+type Cutter interface {
+	Cut() error // stop the motor now, dropping its power without a soft stop
+}
+
+// SpeedSetter implemented by drives that can run slower than full speed
+type SpeedSetter interface {
+	SetSpeed(speed float64) error // the fraction of full speed (0, 1] to run at
+}
+
+// Positioner implemented by drives that know where the car is
+type Positioner interface {
+	Position() (floor float64, known bool) // the car's position in floors, 1.0 is the bottom floor
+}
+
+// TravelObserver implemented by drives that need to know which way the car is really going
+type TravelObserver interface {
+	ObserveTravel(up bool) bool // the car was seen travelling up (or down), returns true if the drive thought otherwise
+}
+
+// FloorMover implemented by drives that can take the car to a floor by themselves
+type FloorMover interface {
+	MoveToFloor(floor int) error // start the car moving to the floor, it stops there on its own
+}
+
+// FloorReporter implemented by drives that know when the car reaches a floor
+type FloorReporter interface {
+	OnFloor(onFloor func(floor int)) // set the function called each time the car reaches a floor
+}
+
+// Homer implemented by drives that have to find a reference position before they can be used
+type Homer interface {
+	Home() error // move the car to the reference position, blocks until done
+}
+
+// drive names used to choose a drive in the controller's configuration
+const (
+	ThreeRelayOpenerName   = "three-button"
+	SingleButtonOpenerName = "single-button"
+	HBridgeName            = "h-bridge"
+	ReversingContactorName = "contactor"
+	StepperName            = "stepper"
+)
+
+// drives the constructor and required pins of each drive that can be built by name
+var drives = map[string]struct {
+	newDrive func(piDevice common.LevelRPi) Drive
+	pins     []common.PiPin
+}{
+	ThreeRelayOpenerName:   {func(p common.LevelRPi) Drive { return NewThreeRelayOpener(p) }, ThreeRelayOpenerPins},
+	SingleButtonOpenerName: {func(p common.LevelRPi) Drive { return NewSingleButtonOpener(p) }, SingleButtonOpenerPins},
+	HBridgeName:            {func(p common.LevelRPi) Drive { return NewHBridge(p, DefaultHBridgeConfig) }, HBridgePins},
+	ReversingContactorName: {func(p common.LevelRPi) Drive { return NewReversingContactor(p, DefaultContactorConfig) }, ReversingContactorPins},
+	StepperName:            {func(p common.LevelRPi) Drive { return NewStepper(p, DefaultStepperConfig) }, StepperPins},
+}
+
+// Names the names of the drives that can be built with New
+func Names() []string {
+	var names []string
+	for name := range drives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New build the named drive with its default configuration
+func New(name string, piDevice common.LevelRPi) (Drive, error) {
+	d, ok := drives[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown drive %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return d.newDrive(piDevice), nil
+}
+
+// RequiredPins the pins the controller's RPi must have wired for the named drive
+func RequiredPins(name string) ([]common.PiPin, error) {
+	d, ok := drives[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown drive %q, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return d.pins, nil
+}
@@ -0,0 +1,257 @@
+package drive
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// fakeOutputs records the level of each output and the number of signals sent, failing the
+// test if both pins of an exclusive pair are ever on together
+type fakeOutputs struct {
+	t         *testing.T
+	values    map[common.PiPin]bool
+	sent      map[common.PiPin]int
+	exclusive [2]common.PiPin
+	mu        sync.Mutex
+}
+
+func newFakeOutputs(t *testing.T, exclusive [2]common.PiPin) *fakeOutputs {
+	return &fakeOutputs{t: t, values: map[common.PiPin]bool{}, sent: map[common.PiPin]int{}, exclusive: exclusive}
+}
+
+func (f *fakeOutputs) SendSignal(pin common.PiPin) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent[pin]++
+	return nil
+}
+
+func (f *fakeOutputs) SetSignal(pin common.PiPin, value bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[pin] = value
+	assert.False(f.t, f.values[f.exclusive[0]] && f.values[f.exclusive[1]], "%s and %s are both on", f.exclusive[0], f.exclusive[1])
+	return nil
+}
+
+func (f *fakeOutputs) GetSignal(pin common.PiPin) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.values[pin], nil
+}
+
+func (f *fakeOutputs) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	return nil, common.ErrWatchNotSupported
+}
+
+func (f *fakeOutputs) value(pin common.PiPin) bool {
+	v, _ := f.GetSignal(pin)
+	return v
+}
+
+// TestNew every named drive can be built and lists its pins
+func TestNew(t *testing.T) {
+	for _, name := range Names() {
+		motor, err := New(name, newFakeOutputs(t, [2]common.PiPin{}))
+		assert.NoError(t, err, name)
+		assert.NotNil(t, motor, name)
+		pins, err := RequiredPins(name)
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, pins, name)
+	}
+	_, err := New("winch", nil)
+	assert.Error(t, err)
+}
+
+// TestThreeRelayOpener each command signals its own relay
+func TestThreeRelayOpener(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{})
+	opener := NewThreeRelayOpener(outputs)
+	assert.NoError(t, opener.Up())
+	assert.NoError(t, opener.Stop())
+	assert.NoError(t, opener.Down())
+	assert.Equal(t, map[common.PiPin]int{common.OpenerUp: 1, common.OpenerStop: 1, common.OpenerDown: 1}, outputs.sent)
+}
+
+// preemptingOutputs fake outputs that count the signals sent ahead of the others
+type preemptingOutputs struct {
+	*fakeOutputs
+	preempted map[common.PiPin]int
+}
+
+func (p *preemptingOutputs) Preempt(pin common.PiPin) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.preempted[pin]++
+	return nil
+}
+
+// TestOpenerCut the openers are stopped with signals sent ahead of the others, when the RPi
+// can preempt
+func TestOpenerCut(t *testing.T) {
+	outputs := &preemptingOutputs{fakeOutputs: newFakeOutputs(t, [2]common.PiPin{}), preempted: map[common.PiPin]int{}}
+	assert.NoError(t, NewThreeRelayOpener(outputs).Cut())
+	assert.Equal(t, map[common.PiPin]int{common.OpenerStop: 1}, outputs.preempted)
+
+	singleButton := NewSingleButtonOpener(outputs)
+	assert.NoError(t, singleButton.Cut(), "already stopped")
+	assert.NoError(t, singleButton.Up())
+	assert.NoError(t, singleButton.Cut())
+	assert.Equal(t, 1, outputs.preempted[common.OpenerButton])
+	assert.Equal(t, cycleStoppedAfterUp, singleButton.getCycle())
+}
+
+// TestSingleButtonPresses the number of presses from each cycle state to each motion
+func TestSingleButtonPresses(t *testing.T) {
+	opener := NewSingleButtonOpener(nil)
+	for _, tc := range []struct {
+		cycle   openerCycle
+		wanted  motion
+		presses int
+	}{
+		{cycleStoppedAfterDown, movingUp, 1},
+		{cycleStoppedAfterDown, movingDown, 3},
+		{cycleStoppedAfterDown, stopped, 0},
+		{cycleMovingUp, stopped, 1},
+		{cycleMovingUp, movingDown, 2},
+		{cycleMovingDown, stopped, 1},
+		{cycleMovingDown, movingUp, 2},
+		{cycleStoppedAfterUp, movingDown, 1},
+	} {
+		opener.cycle = tc.cycle
+		assert.Equal(t, tc.presses, opener.pressesFor(tc.wanted), "from %s to %s", tc.cycle, tc.wanted)
+	}
+}
+
+// TestSingleButtonDrive the button is pressed until the opener reaches the wanted motion
+func TestSingleButtonDrive(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{})
+	opener := NewSingleButtonOpener(outputs)
+
+	assert.NoError(t, opener.Down())
+	assert.Equal(t, 3, outputs.sent[common.OpenerButton])
+	assert.Equal(t, cycleMovingDown, opener.getCycle())
+	assert.NoError(t, opener.Stop())
+	assert.Equal(t, 4, outputs.sent[common.OpenerButton])
+	assert.Equal(t, cycleStoppedAfterDown, opener.getCycle())
+}
+
+// TestSingleButtonObserveTravel the believed cycle follows the direction the car is seen travelling
+func TestSingleButtonObserveTravel(t *testing.T) {
+	opener := NewSingleButtonOpener(newFakeOutputs(t, [2]common.PiPin{}))
+	opener.Up()
+
+	assert.False(t, opener.ObserveTravel(true))
+	assert.True(t, opener.ObserveTravel(false))
+	assert.Equal(t, cycleMovingDown, opener.getCycle())
+}
+
+// TestReversingContactor reversing releases the energized contactor and waits for it to drop out
+func TestReversingContactor(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{common.ContactorUp, common.ContactorDown})
+	clock := common.NewFakeClock(time.Unix(0, 0))
+	contactor := NewReversingContactor(outputs, DefaultContactorConfig).SetClock(clock)
+
+	assert.NoError(t, contactor.Up())
+	assert.True(t, outputs.value(common.ContactorUp))
+
+	done := make(chan error)
+	go func() { done <- contactor.Down() }()
+	clock.BlockUntil(1)
+	assert.False(t, outputs.value(common.ContactorUp), "up should be released during the interlock delay")
+	assert.False(t, outputs.value(common.ContactorDown), "down should wait out the interlock delay")
+	clock.Advance(DefaultContactorConfig.InterlockDelay)
+	assert.NoError(t, <-done)
+	assert.True(t, outputs.value(common.ContactorDown))
+
+	assert.NoError(t, contactor.Stop())
+	assert.False(t, outputs.value(common.ContactorDown))
+}
+
+// TestReversingContactorStopThenReverse a contactor that was just stopped is given time to
+// drop out before the other is energized
+func TestReversingContactorStopThenReverse(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{common.ContactorUp, common.ContactorDown})
+	clock := common.NewFakeClock(time.Unix(0, 0))
+	contactor := NewReversingContactor(outputs, DefaultContactorConfig).SetClock(clock)
+	assert.NoError(t, contactor.Up())
+	assert.NoError(t, contactor.Stop())
+
+	done := make(chan error)
+	go func() { done <- contactor.Down() }()
+	clock.BlockUntil(1)
+	assert.False(t, outputs.value(common.ContactorDown), "down should wait out the interlock delay")
+	clock.Advance(DefaultContactorConfig.InterlockDelay)
+	assert.NoError(t, <-done)
+	assert.True(t, outputs.value(common.ContactorDown))
+
+	assert.NoError(t, contactor.Stop())
+	clock.Advance(DefaultContactorConfig.InterlockDelay)
+	assert.NoError(t, contactor.Up(), "up doesn't wait once down has had time to drop out")
+	assert.True(t, outputs.value(common.ContactorUp))
+}
+
+// TestHBridgeSoftStartAndReverse the motor ramps up to speed, and ramps to a stop before reversing
+func TestHBridgeSoftStartAndReverse(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{common.MotorForward, common.MotorReverse})
+	bridge := NewHBridge(outputs, HBridgeConfig{RampTime: 20 * time.Millisecond, PWMPeriod: time.Millisecond})
+
+	assert.NoError(t, bridge.Up())
+	time.Sleep(5 * time.Millisecond)
+	assert.True(t, bridge.Duty() < 1, "the motor should still be ramping up")
+	waitForDuty(t, bridge, 1)
+	assert.True(t, outputs.value(common.MotorForward))
+
+	assert.NoError(t, bridge.SetSpeed(0.5))
+	waitForDuty(t, bridge, 0.5)
+
+	assert.NoError(t, bridge.Down())
+	waitFor(t, "reverse", func() bool { return outputs.value(common.MotorReverse) })
+	assert.False(t, outputs.value(common.MotorForward))
+	waitForDuty(t, bridge, 0.5)
+
+	assert.NoError(t, bridge.Stop())
+	waitForDuty(t, bridge, 0)
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, outputs.value(common.MotorReverse))
+	assert.False(t, outputs.value(common.MotorEnable))
+	assert.Error(t, bridge.SetSpeed(2))
+}
+
+// TestHBridgeCut a cut drops the motor at full speed straight to a stop, without ramping
+func TestHBridgeCut(t *testing.T) {
+	outputs := newFakeOutputs(t, [2]common.PiPin{common.MotorForward, common.MotorReverse})
+	bridge := NewHBridge(outputs, HBridgeConfig{RampTime: 20 * time.Millisecond, PWMPeriod: time.Millisecond})
+	assert.NoError(t, bridge.Up())
+	waitForDuty(t, bridge, 1)
+
+	assert.NoError(t, bridge.Cut())
+	assert.Equal(t, 0.0, bridge.Duty())
+	assert.False(t, outputs.value(common.MotorEnable))
+	assert.False(t, outputs.value(common.MotorForward))
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, outputs.value(common.MotorEnable), "the pwm loop leaves the motor off")
+}
+
+func waitForDuty(t *testing.T, bridge *HBridge, duty float64) {
+	t.Helper()
+	waitFor(t, fmt.Sprintf("duty %v", duty), func() bool { return bridge.Duty() == duty })
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Fail(t, "timed out waiting for "+what)
+}
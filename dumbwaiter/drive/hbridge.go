@@ -0,0 +1,209 @@
+package drive
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// HBridgePins the pins an h-bridge motor driver is wired to
+var HBridgePins = []common.PiPin{common.MotorForward, common.MotorReverse, common.MotorEnable}
+
+// DefaultHBridgeConfig a one second soft start and stop with 100Hz pwm
+var DefaultHBridgeConfig = HBridgeConfig{RampTime: time.Second, PWMPeriod: 10 * time.Millisecond}
+
+// HBridgeConfig speed control of an h-bridge driven dc motor
+type HBridgeConfig struct {
+	RampTime  time.Duration // time to ramp between stopped and full speed
+	PWMPeriod time.Duration // period of the software pwm on the enable pin
+}
+
+// HBridge drives a dc motor through an h-bridge: the forward and reverse pins set the
+// direction and software pwm on the enable pin sets the speed.  Starts and stops are
+// ramped, and the motor is always ramped to a stop before the direction pins change.
+type HBridge struct {
+	piDevice common.LevelRPi
+	clock    common.Clock
+	config   HBridgeConfig
+
+	speed   float64 // the fraction of full speed to run at
+	wanted  motion  // the motion the controller asked for
+	current motion  // the motion the direction pins are set for
+	duty    float64 // the enable pin's current pwm duty cycle
+	err     error   // the last pin error from the pwm loop
+	started bool
+	mu      sync.Mutex
+	wake    chan struct{}
+}
+
+// NewHBridge create a drive that runs the motor through piDevice
+func NewHBridge(piDevice common.LevelRPi, config HBridgeConfig) *HBridge {
+	return &HBridge{
+		piDevice: piDevice,
+		clock:    common.RealClock,
+		config:   config,
+		speed:    1,
+		wanted:   stopped,
+		current:  stopped,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Up ramp the motor up to speed going up
+func (h *HBridge) Up() error {
+	return h.command(movingUp)
+}
+
+// Down ramp the motor up to speed going down
+func (h *HBridge) Down() error {
+	return h.command(movingDown)
+}
+
+// Stop ramp the motor down to a stop
+func (h *HBridge) Stop() error {
+	return h.command(stopped)
+}
+
+// Cut drop the enable and direction pins at once, without ramping down
+func (h *HBridge) Cut() error {
+	h.mu.Lock()
+	h.wanted, h.current, h.duty = stopped, stopped, 0
+	var err error
+	for _, pin := range []common.PiPin{common.MotorEnable, common.MotorForward, common.MotorReverse} {
+		if pinErr := h.piDevice.SetSignal(pin, false); pinErr != nil && err == nil {
+			err = fmt.Errorf("cutting %s: %w", pin, pinErr)
+		}
+	}
+	h.mu.Unlock()
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// SetSpeed set the fraction of full speed to run at, the motor ramps to the new speed
+func (h *HBridge) SetSpeed(speed float64) error {
+	if speed <= 0 || speed > 1 {
+		return fmt.Errorf("speed %v out of range (0, 1]", speed)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.speed = speed
+	return nil
+}
+
+// Duty the enable pin's current duty cycle, 0 when the motor is stopped
+func (h *HBridge) Duty() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.duty
+}
+
+// command set the wanted motion, returning any pin error the pwm loop has hit since the last command
+func (h *HBridge) command(wanted motion) error {
+	h.mu.Lock()
+	h.wanted = wanted
+	err := h.err
+	h.err = nil
+	if !h.started {
+		h.started = true
+		go h.run()
+	}
+	h.mu.Unlock()
+
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// run ramp the duty cycle toward the wanted motion and generate the pwm
+func (h *HBridge) run() {
+	for {
+		h.mu.Lock()
+		h.rampLocked()
+		duty := h.duty
+		idle := duty == 0 && h.current == stopped && h.wanted == stopped
+		h.mu.Unlock()
+
+		if idle {
+			h.setPin(common.MotorEnable, false)
+			<-h.wake
+			continue
+		}
+		if duty > 0 {
+			h.mu.Lock()
+			if h.duty > 0 { // not cut meanwhile
+				h.setPinLocked(common.MotorEnable, true)
+			}
+			h.mu.Unlock()
+			h.clock.Sleep(time.Duration(duty * float64(h.config.PWMPeriod)))
+		}
+		if duty < 1 {
+			h.setPin(common.MotorEnable, false)
+			h.clock.Sleep(time.Duration((1 - duty) * float64(h.config.PWMPeriod)))
+		}
+	}
+}
+
+// rampLocked move the duty one pwm period's worth toward its target, changing the direction
+// pins once the motor has ramped to a stop.  The caller must hold mu.
+func (h *HBridge) rampLocked() {
+	target := 0.0
+	if h.wanted != stopped && h.wanted == h.current {
+		target = h.speed
+	}
+	step := 1.0
+	if h.config.RampTime > 0 {
+		step = float64(h.config.PWMPeriod) / float64(h.config.RampTime)
+	}
+	if h.duty < target {
+		h.duty = math.Min(h.duty+step, target)
+	} else if h.duty > target {
+		h.duty = math.Max(h.duty-step, target)
+	}
+
+	if h.duty == 0 && h.current != h.wanted {
+		log.Infof("h-bridge direction changing from %s to %s", h.current, h.wanted)
+		// release both sides before setting the new one so the bridge never shorts
+		h.setPinLocked(common.MotorForward, false)
+		h.setPinLocked(common.MotorReverse, false)
+		switch h.wanted {
+		case movingUp:
+			h.setPinLocked(common.MotorForward, true)
+		case movingDown:
+			h.setPinLocked(common.MotorReverse, true)
+		}
+		h.current = h.wanted
+	}
+}
+
+func (h *HBridge) setPin(pin common.PiPin, value bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setPinLocked(pin, value)
+}
+
+// setPinLocked set a pin, keeping the error for the next command.  The caller must hold mu.
+func (h *HBridge) setPinLocked(pin common.PiPin, value bool) {
+	if err := h.piDevice.SetSignal(pin, value); err != nil {
+		log.Errorf("h-bridge failed setting %s: %v", pin, err)
+		h.err = err
+	}
+}
+
+// HBridge constructor setters for builder pattern
+
+// SetClock used by testing to control time
+func (h *HBridge) SetClock(clock common.Clock) *HBridge {
+	h.clock = clock
+	return h
+}
@@ -0,0 +1,39 @@
+package drive
+
+import "github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+
+// ThreeRelayOpenerPins the pins a three relay opener is wired to
+var ThreeRelayOpenerPins = []common.PiPin{common.OpenerUp, common.OpenerDown, common.OpenerStop}
+
+// ThreeRelayOpener drives a garage door opener that has separate up, down and stop relays
+type ThreeRelayOpener struct {
+	piDevice common.RPi
+}
+
+// NewThreeRelayOpener create a drive that signals the opener's relays through piDevice
+func NewThreeRelayOpener(piDevice common.RPi) *ThreeRelayOpener {
+	return &ThreeRelayOpener{piDevice: piDevice}
+}
+
+// Up signal the up relay
+func (o *ThreeRelayOpener) Up() error {
+	return o.piDevice.SendSignal(common.OpenerUp)
+}
+
+// Down signal the down relay
+func (o *ThreeRelayOpener) Down() error {
+	return o.piDevice.SendSignal(common.OpenerDown)
+}
+
+// Stop signal the stop relay
+func (o *ThreeRelayOpener) Stop() error {
+	return o.piDevice.SendSignal(common.OpenerStop)
+}
+
+// Cut signal the stop relay ahead of any signal in progress, on RPis that can preempt
+func (o *ThreeRelayOpener) Cut() error {
+	if preempter, ok := o.piDevice.(common.Preempter); ok {
+		return preempter.Preempt(common.OpenerStop)
+	}
+	return o.Stop()
+}
@@ -0,0 +1,163 @@
+package drive
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// SingleButtonOpenerPins the pins a single button opener is wired to
+var SingleButtonOpenerPins = []common.PiPin{common.OpenerButton}
+
+// motion the way the car is going
+type motion int
+
+const (
+	movingUp motion = iota
+	movingDown
+	stopped
+)
+
+func (m motion) String() string {
+	return [...]string{"up", "down", "stopped"}[m]
+}
+
+// openerCycle a single button opener's place in its up, stop, down, stop cycle,
+// each press of the button moves the opener to the next state
+type openerCycle int
+
+// openerCycle constants in the order the opener steps through them
+const (
+	cycleMovingUp openerCycle = iota
+	cycleStoppedAfterUp
+	cycleMovingDown
+	cycleStoppedAfterDown
+	numCycleStates
+)
+
+func (o openerCycle) String() string {
+	return [...]string{"moving up", "stopped after up", "moving down", "stopped after down"}[o]
+}
+
+// next the state one button press moves the opener to
+func (o openerCycle) next() openerCycle {
+	return (o + 1) % numCycleStates
+}
+
+// motion the car's motion in this state
+func (o openerCycle) motion() motion {
+	switch o {
+	case cycleMovingUp:
+		return movingUp
+	case cycleMovingDown:
+		return movingDown
+	}
+	return stopped
+}
+
+// SingleButtonOpener drives an opener that has one wall button, tracking where the opener
+// is in its cycle so it knows how many presses get the wanted motion
+type SingleButtonOpener struct {
+	piDevice common.RPi
+	cycle    openerCycle // the state the opener is believed to be in
+	mu       sync.Mutex
+}
+
+// NewSingleButtonOpener create a drive that presses the opener's button through piDevice.
+// The opener is assumed to have last closed, so the first press moves up.
+func NewSingleButtonOpener(piDevice common.RPi) *SingleButtonOpener {
+	return &SingleButtonOpener{piDevice: piDevice, cycle: cycleStoppedAfterDown}
+}
+
+// Up press the button until the opener is moving up
+func (o *SingleButtonOpener) Up() error {
+	return o.drive(movingUp)
+}
+
+// Down press the button until the opener is moving down
+func (o *SingleButtonOpener) Down() error {
+	return o.drive(movingDown)
+}
+
+// Stop press the button until the opener is stopped
+func (o *SingleButtonOpener) Stop() error {
+	return o.drive(stopped)
+}
+
+// Cut press the button ahead of any press in progress when the opener is moving, on RPis
+// that can preempt.  A press that was cut short is taken not to have registered.
+func (o *SingleButtonOpener) Cut() error {
+	if o.getCycle().motion() == stopped {
+		return nil
+	}
+	preempter, ok := o.piDevice.(common.Preempter)
+	if !ok {
+		return o.Stop()
+	}
+	if err := preempter.Preempt(common.OpenerButton); err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cycle = o.cycle.next()
+	log.Infof("single button opener pressed ahead of the others, now %s", o.cycle)
+	return nil
+}
+
+// ObserveTravel correct the believed cycle state from the direction the car is seen travelling
+func (o *SingleButtonOpener) ObserveTravel(up bool) bool {
+	observed := movingDown
+	if up {
+		observed = movingUp
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cycle.motion() == observed {
+		return false
+	}
+	resynced := cycleMovingUp
+	if observed == movingDown {
+		resynced = cycleMovingDown
+	}
+	log.Warnf("single button opener drifted, believed %s but the car is going %s, resyncing to %s", o.cycle, observed, resynced)
+	o.cycle = resynced
+	return true
+}
+
+// pressesFor the number of presses that get the opener from its current state to the wanted
+// motion.  Passing through the other direction on the way can't be avoided.
+func (o *SingleButtonOpener) pressesFor(wanted motion) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cycle := o.cycle
+	for presses := 0; presses < int(numCycleStates); presses++ {
+		if cycle.motion() == wanted {
+			return presses
+		}
+		cycle = cycle.next()
+	}
+	return 0 // unreachable, every motion is in the cycle
+}
+
+// drive press the button until the opener has the wanted motion
+func (o *SingleButtonOpener) drive(wanted motion) error {
+	presses := o.pressesFor(wanted)
+	for i := 0; i < presses; i++ {
+		if err := o.piDevice.SendSignal(common.OpenerButton); err != nil {
+			return err
+		}
+		o.mu.Lock()
+		o.cycle = o.cycle.next()
+		log.Infof("single button opener pressed, now %s", o.cycle)
+		o.mu.Unlock()
+	}
+	return nil
+}
+
+func (o *SingleButtonOpener) getCycle() openerCycle {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.cycle
+}
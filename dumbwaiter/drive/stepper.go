@@ -0,0 +1,352 @@
+package drive
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// StepperPins the pins a stepper driver is wired to
+var StepperPins = []common.PiPin{common.StepperStep, common.StepperDirection, common.StepperEnable, common.StepperHome}
+
+// ErrNotHomed returned when the stepper is asked to go to a floor before it knows where the car is
+var ErrNotHomed = errors.New("stepper has not been homed")
+
+// DefaultStepperConfig a small shaft with a 200 step motor geared so each floor is 20000 steps
+var DefaultStepperConfig = StepperConfig{
+	StepsPerFloor:  20000,
+	MinStepRate:    200,
+	MaxStepRate:    4000,
+	Acceleration:   4000,
+	StepPulse:      5 * time.Microsecond,
+	HomingStepRate: 400,
+	MaxHomingSteps: 20000 * 10,
+}
+
+// StepperConfig the motion profile of a stepper drive
+type StepperConfig struct {
+	StepsPerFloor  int           // steps between adjacent floors
+	MinStepRate    float64       // steps per second the motor starts and stops at
+	MaxStepRate    float64       // steps per second at full speed
+	Acceleration   float64       // steps per second per second for the accel and decel ramps
+	StepPulse      time.Duration // width of the step pulse
+	HomingStepRate float64       // steps per second while homing
+	MaxHomingSteps int           // homing fails if the home switch isn't found within this many steps
+}
+
+// stepperMode what the stepper is doing
+type stepperMode int
+
+const (
+	stepperIdle     stepperMode = iota
+	stepperRunning              // running in a direction until stopped
+	stepperToTarget             // running to a step position
+	stepperStopping             // decelerating to a stop
+	stepperHoming               // moving down slowly until the home switch closes
+)
+
+// Stepper drives a stepper motor through a step/dir driver.  The car's position is the step
+// count above the bottom floor, known once the stepper has been homed, and each floor the car
+// reaches is reported to the floor callback.
+type Stepper struct {
+	piDevice common.LevelRPi
+	clock    common.Clock
+	config   StepperConfig
+
+	mode        stepperMode
+	position    int     // steps above the bottom floor
+	target      int     // the step position to stop at in stepperToTarget mode
+	runUp       bool    // the direction to run in stepperRunning mode
+	up          bool    // the direction the motor is turning (or last turned)
+	rate        float64 // current steps per second, 0 when stopped
+	speed       float64 // fraction of MaxStepRate to run at
+	homed       bool
+	homingSteps int
+	homeDone    chan error
+	onFloor     func(floor int)
+	started     bool
+	mu          sync.Mutex
+	wake        chan struct{}
+}
+
+// NewStepper create a drive that steps the motor through piDevice
+func NewStepper(piDevice common.LevelRPi, config StepperConfig) *Stepper {
+	return &Stepper{
+		piDevice: piDevice,
+		clock:    common.RealClock,
+		config:   config,
+		speed:    1,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Up run the car up until stopped
+func (s *Stepper) Up() error {
+	return s.command(func() { s.mode, s.runUp = stepperRunning, true })
+}
+
+// Down run the car down until stopped
+func (s *Stepper) Down() error {
+	return s.command(func() { s.mode, s.runUp = stepperRunning, false })
+}
+
+// Stop decelerate the car to a stop
+func (s *Stepper) Stop() error {
+	return s.command(func() {
+		if s.mode != stepperIdle {
+			s.mode = stepperStopping
+		}
+	})
+}
+
+// Cut stop stepping and drop the enable pin at once, without decelerating.  A homing in
+// progress fails.  The position is kept, but a motor cut at speed may have slipped.
+func (s *Stepper) Cut() error {
+	s.mu.Lock()
+	if s.mode == stepperHoming {
+		s.homeDone <- errors.New("homing cut short")
+	}
+	if s.rate > s.config.MinStepRate {
+		log.Warnf("stepper cut at %.0f steps/s, the car's position may be off", s.rate)
+	}
+	s.mode, s.rate = stepperIdle, 0
+	s.mu.Unlock()
+	return s.piDevice.SetSignal(common.StepperEnable, false)
+}
+
+// MoveToFloor run the car to the floor's exact step position, ramping up and down on the way
+func (s *Stepper) MoveToFloor(floor int) error {
+	s.mu.Lock()
+	homed := s.homed
+	s.mu.Unlock()
+	if !homed {
+		return ErrNotHomed
+	}
+	if floor < 1 {
+		return fmt.Errorf("can't move to floor %d", floor)
+	}
+	return s.command(func() { s.mode, s.target = stepperToTarget, (floor-1)*s.config.StepsPerFloor })
+}
+
+// Home move the car down at the homing rate until the home switch closes, the position there
+// is the bottom floor.  Blocks until homing is done.
+func (s *Stepper) Home() error {
+	done := make(chan error, 1)
+	s.command(func() {
+		s.mode, s.homed, s.homingSteps, s.homeDone = stepperHoming, false, 0, done
+	})
+	return <-done
+}
+
+// Position the car's position in floors, known once the stepper has been homed
+func (s *Stepper) Position() (float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return 1 + float64(s.position)/float64(s.config.StepsPerFloor), s.homed
+}
+
+// SetSpeed set the fraction of the maximum step rate to run at
+func (s *Stepper) SetSpeed(speed float64) error {
+	if speed <= 0 || speed > 1 {
+		return fmt.Errorf("speed %v out of range (0, 1]", speed)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.speed = speed
+	return nil
+}
+
+// OnFloor set the function called with the floor number each time the car reaches a floor
+func (s *Stepper) OnFloor(onFloor func(floor int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onFloor = onFloor
+}
+
+// command change the mode and wake the step loop
+func (s *Stepper) command(change func()) error {
+	s.mu.Lock()
+	change()
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run generate the step pulses for the current mode
+func (s *Stepper) run() {
+	for {
+		s.mu.Lock()
+		up, rate, ok := s.nextStepLocked()
+		idle := s.mode == stepperIdle
+		s.mu.Unlock()
+		if !ok {
+			if idle {
+				s.setPin(common.StepperEnable, false)
+				<-s.wake
+			}
+			continue // not idle means the motor has just stopped to reverse
+		}
+
+		s.setPin(common.StepperEnable, true)
+		s.setPin(common.StepperDirection, up)
+		s.setPin(common.StepperStep, true)
+		s.clock.Sleep(s.config.StepPulse)
+		s.setPin(common.StepperStep, false)
+
+		s.mu.Lock()
+		if up {
+			s.position++
+		} else {
+			s.position--
+		}
+		onFloor, floor := s.onFloor, 0
+		if s.homed && s.position%s.config.StepsPerFloor == 0 {
+			floor = s.position/s.config.StepsPerFloor + 1
+		}
+		s.mu.Unlock()
+
+		if floor != 0 && onFloor != nil {
+			onFloor(floor)
+		}
+		s.clock.Sleep(time.Duration(float64(time.Second)/rate) - s.config.StepPulse)
+	}
+}
+
+// nextStepLocked decide the direction and rate of the next step, ok is false when the motor
+// should not step.  The caller must hold mu.
+func (s *Stepper) nextStepLocked() (up bool, rate float64, ok bool) {
+	switch s.mode {
+	case stepperHoming:
+		return s.homingStepLocked()
+	case stepperRunning:
+		if s.rate > 0 && s.up != s.runUp {
+			return s.decelerateLocked() // reversing, stop first
+		}
+		s.up = s.runUp
+		return s.up, s.accelerateLocked(), true
+	case stepperStopping:
+		return s.decelerateLocked()
+	case stepperToTarget:
+		remaining := s.target - s.position
+		if remaining == 0 {
+			s.mode, s.rate = stepperIdle, 0
+			return false, 0, false
+		}
+		wantUp := remaining > 0
+		if s.rate > 0 && s.up != wantUp {
+			return s.decelerateLocked() // overshot or reversing, stop and come back
+		}
+		s.up = wantUp
+		if abs(remaining) <= s.stepsToStopLocked() {
+			if s.rate = s.slowerLocked(); s.rate == 0 {
+				s.rate = s.config.MinStepRate // creep the last steps
+			}
+			return s.up, s.rate, true
+		}
+		return s.up, s.accelerateLocked(), true
+	}
+	return false, 0, false
+}
+
+// homingStepLocked step down slowly until the home switch closes
+func (s *Stepper) homingStepLocked() (bool, float64, bool) {
+	home, err := s.piDevice.GetSignal(common.StepperHome)
+	if err != nil || home || s.homingSteps >= s.config.MaxHomingSteps {
+		switch {
+		case err != nil:
+			err = fmt.Errorf("reading home switch: %w", err)
+		case home:
+			s.position, s.homed = 0, true
+			log.Info("stepper homed at the bottom floor")
+			if s.onFloor != nil {
+				go s.onFloor(1)
+			}
+		default:
+			err = fmt.Errorf("home switch not found within %d steps", s.config.MaxHomingSteps)
+		}
+		s.mode, s.rate = stepperIdle, 0
+		s.homeDone <- err
+		return false, 0, false
+	}
+	s.homingSteps++
+	s.up, s.rate = false, s.config.HomingStepRate
+	return false, s.rate, true
+}
+
+// accelerateLocked the rate for the next step ramping toward the top speed
+func (s *Stepper) accelerateLocked() float64 {
+	top := math.Max(s.config.MaxStepRate*s.speed, s.config.MinStepRate)
+	switch {
+	case s.rate < s.config.MinStepRate:
+		s.rate = s.config.MinStepRate
+	case s.rate > top: // the speed was turned down
+		s.rate = math.Max(s.slowerLocked(), top)
+	default:
+		s.rate = math.Min(math.Sqrt(s.rate*s.rate+2*s.config.Acceleration), top)
+	}
+	return s.rate
+}
+
+// decelerateLocked the next step ramping down, the motor stops once it is down to the minimum rate
+func (s *Stepper) decelerateLocked() (bool, float64, bool) {
+	if s.rate = s.slowerLocked(); s.rate == 0 {
+		if s.mode == stepperStopping {
+			s.mode = stepperIdle
+		}
+		return false, 0, false
+	}
+	return s.up, s.rate, true
+}
+
+// slowerLocked the rate one step of deceleration slower, 0 once below the minimum rate
+func (s *Stepper) slowerLocked() float64 {
+	squared := s.rate*s.rate - 2*s.config.Acceleration
+	if squared < s.config.MinStepRate*s.config.MinStepRate {
+		return 0
+	}
+	return math.Sqrt(squared)
+}
+
+// stepsToStopLocked the number of steps needed to decelerate from the current rate
+func (s *Stepper) stepsToStopLocked() int {
+	min := s.config.MinStepRate
+	if s.rate <= min {
+		return 0
+	}
+	return int(math.Ceil((s.rate*s.rate - min*min) / (2 * s.config.Acceleration)))
+}
+
+func (s *Stepper) setPin(pin common.PiPin, value bool) {
+	if err := s.piDevice.SetSignal(pin, value); err != nil {
+		log.Errorf("stepper failed setting %s: %v", pin, err)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Stepper constructor setters for builder pattern
+
+// SetClock used by testing to control time
+func (s *Stepper) SetClock(clock common.Clock) *Stepper {
+	s.clock = clock
+	return s
+}
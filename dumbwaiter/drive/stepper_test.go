@@ -0,0 +1,174 @@
+package drive
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+var testStepperConfig = StepperConfig{
+	StepsPerFloor:  50,
+	MinStepRate:    5000,
+	MaxStepRate:    20000,
+	Acceleration:   2e6,
+	StepPulse:      time.Microsecond,
+	HomingStepRate: 10000,
+	MaxHomingSteps: 500,
+}
+
+// stepperRig a simulated car on a stepper: it counts the step pulses and closes the home
+// switch when the car reaches the bottom
+type stepperRig struct {
+	position  int // steps above the bottom floor
+	dirUp     bool
+	step      bool
+	reversals int // direction changes while moving
+	lastUp    bool
+	moved     bool
+	noHome    bool // the home switch is broken
+	mu        sync.Mutex
+}
+
+func (r *stepperRig) SendSignal(pin common.PiPin) error {
+	return r.SetSignal(pin, true)
+}
+
+func (r *stepperRig) SetSignal(pin common.PiPin, value bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch pin {
+	case common.StepperDirection:
+		r.dirUp = value
+	case common.StepperStep:
+		if value && !r.step {
+			if r.moved && r.dirUp != r.lastUp {
+				r.reversals++
+			}
+			r.moved, r.lastUp = true, r.dirUp
+			if r.dirUp {
+				r.position++
+			} else {
+				r.position--
+			}
+		}
+		r.step = value
+	}
+	return nil
+}
+
+func (r *stepperRig) GetSignal(pin common.PiPin) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return pin == common.StepperHome && !r.noHome && r.position <= 0, nil
+}
+
+func (r *stepperRig) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	return nil, common.ErrWatchNotSupported
+}
+
+func (r *stepperRig) get() (position int, reversals int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.position, r.reversals
+}
+
+// floorRecorder collects the floors a drive reports
+type floorRecorder struct {
+	floors []int
+	mu     sync.Mutex
+}
+
+func (f *floorRecorder) onFloor(floor int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.floors = append(f.floors, floor)
+}
+
+func (f *floorRecorder) get() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.floors...)
+}
+
+// TestStepperHomeAndMoveToFloor homing finds the bottom floor, then the car is stepped to a
+// floor's exact position reporting each floor it reaches
+func TestStepperHomeAndMoveToFloor(t *testing.T) {
+	rig := &stepperRig{position: 70}
+	floors := &floorRecorder{}
+	stepper := NewStepper(rig, testStepperConfig)
+	stepper.OnFloor(floors.onFloor)
+
+	assert.Equal(t, ErrNotHomed, stepper.MoveToFloor(2))
+	assert.NoError(t, stepper.Home())
+	position, known := stepper.Position()
+	assert.True(t, known)
+	assert.Equal(t, 1.0, position)
+
+	assert.NoError(t, stepper.MoveToFloor(3))
+	waitFor(t, "floor 3", func() bool { p, _ := stepper.Position(); return p == 3 })
+	time.Sleep(10 * time.Millisecond) // no more steps once there
+
+	rigPosition, reversals := rig.get()
+	assert.Equal(t, 100, rigPosition)
+	assert.Equal(t, 1, reversals, "only the reversal from homing down to going up")
+	waitFor(t, "floor reports", func() bool { return len(floors.get()) == 3 })
+	assert.Equal(t, []int{1, 2, 3}, floors.get())
+}
+
+// TestStepperHomingFails homing gives up when the home switch isn't found
+func TestStepperHomingFails(t *testing.T) {
+	rig := &stepperRig{position: 70, noHome: true}
+	stepper := NewStepper(rig, testStepperConfig)
+
+	assert.Error(t, stepper.Home())
+	_, known := stepper.Position()
+	assert.False(t, known)
+}
+
+// TestStepperRamp a move ramps up to the maximum rate and back down, ending on the target
+func TestStepperRamp(t *testing.T) {
+	stepper := NewStepper(&stepperRig{}, testStepperConfig)
+	stepper.homed = true
+	stepper.mode, stepper.target = stepperToTarget, 200
+
+	var rates []float64
+	for steps := 0; steps < 1000; steps++ {
+		up, rate, ok := stepper.nextStepLocked()
+		if !ok {
+			break
+		}
+		assert.True(t, up)
+		rates = append(rates, rate)
+		stepper.position++
+	}
+
+	assert.Equal(t, 200, stepper.position)
+	assert.Equal(t, stepperIdle, stepper.mode)
+	assert.Equal(t, testStepperConfig.MinStepRate, rates[0], "start at the minimum rate")
+	assert.Equal(t, testStepperConfig.MaxStepRate, rates[len(rates)/2], "cruise at the maximum rate")
+	assert.True(t, rates[len(rates)-1] < testStepperConfig.MaxStepRate/2, "slow down before the target")
+	for i := 1; i < len(rates)/2; i++ {
+		assert.True(t, rates[i] >= rates[i-1], "ramping up at step %d", i)
+	}
+}
+
+// TestStepperCut a cut stops the stepping at once, and fails a homing in progress
+func TestStepperCut(t *testing.T) {
+	rig := &stepperRig{position: 70, noHome: true}
+	stepper := NewStepper(rig, StepperConfig{StepsPerFloor: 50, MinStepRate: 100, MaxStepRate: 100, Acceleration: 1, StepPulse: time.Microsecond,
+		HomingStepRate: 100, MaxHomingSteps: 500})
+	homed := make(chan error, 1)
+	go func() { homed <- stepper.Home() }()
+	waitFor(t, "homing steps", func() bool { position, _ := rig.get(); return position < 70 })
+
+	assert.NoError(t, stepper.Cut())
+	assert.Error(t, <-homed)
+	position, _ := rig.get()
+	time.Sleep(50 * time.Millisecond)
+	stopped, _ := rig.get()
+	assert.True(t, position-stopped <= 1, "at most the step in progress")
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+)
+
+// HTTPSensors is the structure for adding the floor's restian endpoints
+type HTTPSensors struct {
+	Sensors     *floor.Sensors
+	ServiceName string
+}
+
+// NewHTTPSensors wrap the floor's sensors with http entrypoints
+func NewHTTPSensors(sensors *floor.Sensors) *HTTPSensors {
+	return &HTTPSensors{Sensors: sensors, ServiceName: "floor"}
+}
+
+// AddEndpoints adds the http endpoints to the server
+func (f *HTTPSensors) AddEndpoints(router *mux.Router) {
+	log.Info("adding floor service endpoints")
+	router.HandleFunc(fmt.Sprintf("/%s/status", f.ServiceName), f.StatusEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/sensors", f.ServiceName), f.SensorsEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/commands", f.ServiceName), f.CommandsEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/controller", f.ServiceName), f.ControllerEndpoint).Methods("GET")
+}
+
+// StatusEndpoint implement the http entry for status requests
+func (f *HTTPSensors) StatusEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("StatusEndpoint request received")
+	writeJSON(w, f.Sensors.GetStatus())
+}
+
+// SensorsEndpoint implement the http entry for the floor's current sensor states
+func (f *HTTPSensors) SensorsEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("SensorsEndpoint request received")
+	status := f.Sensors.GetStatus()
+	writeJSON(w, struct {
+		AtFloor      bool
+		StopPressed  bool
+		CallsPressed []int
+	}{status.AtFloor, status.StopPressed, status.CallsPressed})
+}
+
+// CommandsEndpoint implement the http entry for the commands the floor last sent the controller
+func (f *HTTPSensors) CommandsEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("CommandsEndpoint request received")
+	writeJSON(w, f.Sensors.GetStatus().LastCommands)
+}
+
+// ControllerEndpoint implement the http entry for checking the floor can reach the controller
+func (f *HTTPSensors) ControllerEndpoint(w http.ResponseWriter, r *http.Request) {
+	log.Info("ControllerEndpoint request received")
+	writeJSON(w, f.Sensors.CheckController())
+}
+
+func writeJSON(w http.ResponseWriter, response interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	log.Infof("returning: %v", response)
+	json.NewEncoder(w).Encode(response)
+}
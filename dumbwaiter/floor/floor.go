@@ -1,6 +1,10 @@
 package floor
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -12,53 +16,217 @@ import (
 
 var defaultLoopFrequency time.Duration = 500 * time.Millisecond
 
+// defaultNumFloors the number of floors (and so call buttons) when not set with SetNumFloors
+const defaultNumFloors = 3
+
+// commandHistorySize how many of the commands most recently sent to the controller are kept,
+// and how many calls and arrivals can wait to be retried (stop requests are never dropped)
+const commandHistorySize = 20
+
+// commandTimeout how long a call to the controller has before it is abandoned
+const commandTimeout = 10 * time.Second
+
+// Status the floor's sensor states and the commands it most recently sent the controller
+type Status struct {
+	FloorNum        int
+	AtFloor         bool
+	StopPressed     bool
+	CallsPressed    []int     // the floors whose call buttons are held down
+	LastCommands    []Command // oldest first
+	PendingCommands int       // commands waiting for the controller to be reachable again
+	Alert           string    `json:",omitempty"` // the controller's fault, until a command succeeds
+}
+
+// Command a call made to the controller
+type Command struct {
+	Time  time.Time
+	Name  string // the controller api function called
+	Floor int    `json:",omitempty"`
+	Error string `json:",omitempty"` // why the call failed
+}
+
+// Connectivity whether the floor can reach the controller
+type Connectivity struct {
+	ControllerURL string
+	Reachable     bool
+	Error         string `json:",omitempty"`
+}
+
+// healthChecker controller clients that can check the controller is up
+type healthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// command a call to make to the controller
+type command struct {
+	name  string
+	floor int
+	stop  bool // a stop request, sent ahead of the other commands
+	send  func(ctx context.Context) error
+}
+
+// commandQueue commands waiting for their sender, in order
+type commandQueue struct {
+	commands []command
+	deferred []command     // calls made while the controller is homing, requeued on the retry tick
+	wake     chan struct{} // signalled when a command is queued
+}
+
+func newCommandQueue() *commandQueue {
+	return &commandQueue{wake: make(chan struct{}, 1)}
+}
+
 // Sensors monitor sensors at each floor and send requests to controller
 type Sensors struct {
-	floorNum           int
-	selectedFloor      []int
-	atFloorSensor      bool
-	stopSelected       bool
-	rpi                common.RPi
-	mainLoopTicker     *time.Ticker
-	loopFreq           time.Duration
-	controllerClient   api.Controller
-	controllerURL      string
-	priorSelectedFloor int
-	priorAtFloor       bool
+	floorNum         int
+	numFloors        int          // the number of floors in the building, there is a call button for each
+	callPressed      map[int]bool // the call buttons (by floor) that are held down, a call is sent when one is pressed
+	atFloorSensor    bool         // the car is at the floor, arrivals and departures are sent when it changes
+	stopSelected     bool         // the stop button is held down
+	rpi              common.RPi
+	mainLoopTicker   *time.Ticker
+	loopFreq         time.Duration
+	controllerClient api.Controller
+	controllerURL    string
+	lastCommands     []Command
+	pending          *commandQueue // calls and arrivals to send, they wait here while the controller is unreachable
+	pendingStops     *commandQueue // stop requests, sent on their own so they don't wait behind the other commands
+	alert            string
+	stateMu          sync.Mutex // guards the sensor states and commands read by GetStatus
 }
 
 // NewSensors create a new sensors object
 func NewSensors(floorNum int, controllerURL string) *Sensors {
 	return &Sensors{
 		floorNum:         floorNum,
-		rpi:              common.NewRPiDevice(),
+		numFloors:        defaultNumFloors,
+		rpi:              common.NewDebouncedRPi(common.NewRPiDevice(common.DefaultPinMap()), common.DefaultDebounceConfig),
 		loopFreq:         defaultLoopFrequency,
 		controllerURL:    controllerURL,
 		controllerClient: cli.NewControllerHTTPClient(controllerURL),
-		priorAtFloor:     false,
+		callPressed:      map[int]bool{},
+		pending:          newCommandQueue(),
+		pendingStops:     newCommandQueue(),
 	}
 }
 
-// StartProcessingLoop start the processing loop in its own goroutine
+// StartProcessingLoop start the processing loop and the command senders in their own goroutines
 func (s *Sensors) StartProcessingLoop() {
+	go s.sendingLoop(s.pendingStops)
+	go s.sendingLoop(s.pending)
 	go s.processingLoop()
 }
 
-// collect the values from the floor's sensors and send them to the controller
+// collect the values from the floor's sensors and send them to the controller.  The sensors
+// are watched for changes when the RPi supports it, otherwise they are polled.
 func (s *Sensors) processingLoop() {
 	log.Infof("Starting floor%d sensor loop", s.floorNum)
+
+	events, err := s.watchSensors()
+	if err != nil {
+		log.Infof("floor%d can't watch its sensors (%v), polling them every %s", s.floorNum, err, s.loopFreq)
+		s.pollingLoop()
+		return
+	}
+	s.eventLoop(events)
+}
+
+// pollingLoop read the sensors on every tick
+func (s *Sensors) pollingLoop() {
 	s.mainLoopTicker = time.NewTicker(s.loopFreq)
 
 	for {
 		select {
 		case <-s.mainLoopTicker.C:
-			s.handleAtFloorSensor()
-			s.handleFloorRequestSensor(common.Floor1Requested, 1)
-			s.handleFloorRequestSensor(common.Floor2Requested, 2)
-			s.handleFloorRequestSensor(common.Floor3Requested, 3)
-			s.handleStopRequestSensor(common.StopRequested)
+			s.pollSensors()
+		}
+	}
+}
+
+// eventLoop handle the sensor changes as they arrive
+func (s *Sensors) eventLoop(events <-chan common.SignalEvent) {
+	s.pollSensors() // pick up the sensors that were already on before the watch started
+	for event := range events {
+		s.handleSensorEvent(event)
+	}
+	log.Warnf("floor%d sensor events ended", s.floorNum)
+}
+
+// sendingLoop send a queue's commands as they are queued, the sensors are never held up by
+// the controller.  Commands that couldn't reach the controller, and calls deferred while it
+// is homing, are retried every loop period.
+func (s *Sensors) sendingLoop(queue *commandQueue) {
+	retryTicker := time.NewTicker(s.loopFreq)
+	defer retryTicker.Stop()
+	for {
+		select {
+		case <-queue.wake:
+		case <-retryTicker.C:
+			s.stateMu.Lock()
+			queue.commands = append(queue.commands, queue.deferred...)
+			queue.deferred = nil
+			s.stateMu.Unlock()
+		}
+		s.sendPending(queue)
+	}
+}
+
+// watchSensors subscribe to all of the floor's sensors, merging their events into one channel
+func (s *Sensors) watchSensors() (<-chan common.SignalEvent, error) {
+	merged := make(chan common.SignalEvent)
+	var watches []<-chan common.SignalEvent
+	for _, pin := range s.sensorPins() {
+		watch, err := s.rpi.WatchSignal(pin)
+		if err != nil {
+			return nil, err
 		}
+		watches = append(watches, watch)
 	}
+
+	var wg sync.WaitGroup
+	for _, watch := range watches {
+		wg.Add(1)
+		go func(watch <-chan common.SignalEvent) {
+			defer wg.Done()
+			for event := range watch {
+				merged <- event
+			}
+		}(watch)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged, nil
+}
+
+// pollSensors read all of the floor's sensors
+func (s *Sensors) pollSensors() {
+	s.handleAtFloorSensor()
+	for i, pin := range common.FloorRequestPins(s.numFloors) {
+		s.handleFloorRequestSensor(pin, i+1)
+	}
+	s.handleStopRequestSensor(common.StopRequested)
+}
+
+// handleSensorEvent act on a single sensor change
+func (s *Sensors) handleSensorEvent(event common.SignalEvent) {
+	log.Debugf("floor%d got %s %s event", s.floorNum, event.Pin, event.Edge)
+	switch event.Pin {
+	case common.AtFloor:
+		s.processAtFloorSensor(event.Value())
+	case common.StopRequested:
+		s.processStopRequestSensor(event.Value())
+	default:
+		if floorNum, ok := event.Pin.RequestedFloor(); ok && floorNum <= s.numFloors {
+			s.processFloorRequestSensor(event.Value(), floorNum)
+		}
+	}
+}
+
+// sensorPins all of the pins the floor reads
+func (s *Sensors) sensorPins() []common.PiPin {
+	return append([]common.PiPin{common.AtFloor, common.StopRequested}, common.FloorRequestPins(s.numFloors)...)
 }
 
 // handleAtFloorSensor sends an atfloor request when the platform reaches this floor
@@ -71,10 +239,26 @@ func (s *Sensors) handleAtFloorSensor() {
 		log.Errorf("error getting AtFloor sensor: %e", err) // TODO implement real error handling
 		return
 	}
-	if sensor && !s.priorAtFloor {
+	s.processAtFloorSensor(sensor)
+}
+
+// processAtFloorSensor the car arrives when the sensor turns on and departs when it turns off
+func (s *Sensors) processAtFloorSensor(sensor bool) {
+	s.stateMu.Lock()
+	prior := s.atFloorSensor
+	s.atFloorSensor = sensor
+	s.stateMu.Unlock()
+
+	if sensor && !prior {
 		log.Infof("sent at floor %d notice to controller", s.floorNum)
-		s.controllerClient.SetLastSeenFloor(s.floorNum)
-		s.priorAtFloor = true
+		s.queueCommand(command{name: "SetLastSeenFloor", floor: s.floorNum, send: func(ctx context.Context) error {
+			return s.controllerClient.SetLastSeenFloor(ctx, s.floorNum)
+		}})
+	} else if !sensor && prior {
+		log.Infof("sent departed floor %d notice to controller", s.floorNum)
+		s.queueCommand(command{name: "SetDepartedFloor", floor: s.floorNum, send: func(ctx context.Context) error {
+			return s.controllerClient.SetDepartedFloor(ctx, s.floorNum)
+		}})
 	}
 }
 
@@ -85,29 +269,160 @@ func (s *Sensors) handleFloorRequestSensor(pin common.PiPin, floorNum int) {
 	if buttonPressed, err = s.rpi.GetSignal(pin); err != nil {
 		log.Errorf("error getting Floor%d button: %e", floorNum, err) // TODO implement real error handling
 	}
-	if buttonPressed && floorNum != s.priorSelectedFloor {
+	s.processFloorRequestSensor(buttonPressed, floorNum)
+}
+
+// processFloorRequestSensor a call is sent when the button is pressed, it re-arms when released
+func (s *Sensors) processFloorRequestSensor(buttonPressed bool, floorNum int) {
+	s.stateMu.Lock()
+	prior := s.callPressed[floorNum]
+	s.callPressed[floorNum] = buttonPressed
+	s.stateMu.Unlock()
+
+	if buttonPressed && !prior {
 		log.Infof("floor %d send call to floor %d to controller", s.floorNum, floorNum)
-		s.controllerClient.SetRequestedFloor(floorNum)
-		s.priorSelectedFloor = floorNum
+		s.queueCommand(command{name: "SetRequestedFloor", floor: floorNum, send: func(ctx context.Context) error {
+			return s.controllerClient.SetRequestedFloor(ctx, floorNum)
+		}})
 	}
 }
 
-//handleStopRequestSensor sends a stop request to the controller
+// handleStopRequestSensor sends a stop request to the controller
 func (s *Sensors) handleStopRequestSensor(pin common.PiPin) {
 	var buttonPressed bool
 	var err error
 	if buttonPressed, err = s.rpi.GetSignal(pin); err != nil {
 		log.Errorf("error getting stop button: %e", err) // TODO implement real error handling
 	}
-	if buttonPressed && !s.stopSelected {
+	s.processStopRequestSensor(buttonPressed)
+}
+
+// processStopRequestSensor a stop is sent when the button is pressed, it re-arms when released
+func (s *Sensors) processStopRequestSensor(buttonPressed bool) {
+	s.stateMu.Lock()
+	prior := s.stopSelected
+	s.stopSelected = buttonPressed
+	s.stateMu.Unlock()
+
+	if buttonPressed && !prior {
 		log.Infof("send stop call to controller")
+		s.queueCommand(command{name: "SetStopRequested", stop: true, send: s.controllerClient.SetStopRequested})
+	}
+}
+
+// queueCommand queue a command for its sender, after any still waiting to be retried.  Stop
+// requests have their own queue, which is never cut short.
+func (s *Sensors) queueCommand(cmd command) {
+	queue := s.pending
+	if cmd.stop {
+		queue = s.pendingStops
+	}
+	s.stateMu.Lock()
+	if !cmd.stop && len(queue.commands) >= commandHistorySize {
+		log.Errorf("floor%d has too many commands waiting for the controller, dropped %s", s.floorNum, queue.commands[0].name)
+		queue.commands = queue.commands[1:]
+	}
+	queue.commands = append(queue.commands, cmd)
+	s.stateMu.Unlock()
 
-		//implement controller stop request
-		s.controllerClient.SetStopRequested()
-		s.stopSelected = true
+	select {
+	case queue.wake <- struct{}{}:
+	default: // the sender is already due to run
 	}
 }
 
+// sendPending send a queue's waiting commands in order.  When the controller can't be reached the
+// command (and those after it) wait to be retried, when it is in fault or emergency stopped
+// the floor raises an alert, and a command for a floor the controller doesn't have is dropped.  A call made while
+// the controller is still looking for the car waits for the next retry tick without holding
+// up the commands after it (they may be what finds the car).
+func (s *Sensors) sendPending(queue *commandQueue) {
+	for {
+		s.stateMu.Lock()
+		if len(queue.commands) == 0 {
+			s.stateMu.Unlock()
+			return
+		}
+		cmd := queue.commands[0]
+		s.stateMu.Unlock()
+
+		ctx, cancel := context.WithTimeout(api.WithRequester(context.Background(), fmt.Sprintf("floor%d", s.floorNum)), commandTimeout)
+		err := cmd.send(ctx)
+		cancel()
+		s.recordCommand(cmd.name, cmd.floor, err)
+		if errors.Is(err, api.ErrControllerUnreachable) || errors.Is(err, context.DeadlineExceeded) {
+			log.Warnf("floor%d will retry %s when the controller is reachable", s.floorNum, cmd.name)
+			return
+		}
+
+		s.stateMu.Lock()
+		queue.commands = queue.commands[1:]
+		switch {
+		case errors.Is(err, api.ErrPositionUnknown):
+			log.Infof("floor%d will retry %s once the controller has found the car", s.floorNum, cmd.name)
+			queue.deferred = append(queue.deferred, cmd)
+		case err == nil:
+			s.alert = ""
+		case errors.Is(err, api.ErrControllerFault), errors.Is(err, api.ErrEmergencyStopped):
+			log.Errorf("ALERT floor%d: %v", s.floorNum, err)
+			s.alert = err.Error()
+		case errors.Is(err, api.ErrInvalidFloor):
+			log.Warnf("floor%d dropped %s, the controller does not serve floor %d (check -num_floors)", s.floorNum, cmd.name, cmd.floor)
+		}
+		s.stateMu.Unlock()
+	}
+}
+
+// recordCommand keep a command sent to the controller for GetStatus, logging its failure
+func (s *Sensors) recordCommand(name string, floor int, err error) {
+	command := Command{Time: time.Now(), Name: name, Floor: floor}
+	if err != nil {
+		log.Errorf("floor%d %s failed: %v", s.floorNum, name, err)
+		command.Error = err.Error()
+	}
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.lastCommands = append(s.lastCommands, command)
+	if len(s.lastCommands) > commandHistorySize {
+		s.lastCommands = s.lastCommands[len(s.lastCommands)-commandHistorySize:]
+	}
+}
+
+// GetStatus get the floor's sensor states and the commands it most recently sent
+func (s *Sensors) GetStatus() *Status {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	status := &Status{
+		FloorNum:        s.floorNum,
+		AtFloor:         s.atFloorSensor,
+		StopPressed:     s.stopSelected,
+		LastCommands:    append([]Command(nil), s.lastCommands...),
+		PendingCommands: len(s.pending.commands) + len(s.pending.deferred) + len(s.pendingStops.commands) + len(s.pendingStops.deferred),
+		Alert:           s.alert,
+	}
+	for floorNum := 1; floorNum <= s.numFloors; floorNum++ {
+		if s.callPressed[floorNum] {
+			status.CallsPressed = append(status.CallsPressed, floorNum)
+		}
+	}
+	return status
+}
+
+// CheckController check that the controller can be reached, controllers that are called
+// in-process are always reachable
+func (s *Sensors) CheckController() *Connectivity {
+	connectivity := &Connectivity{ControllerURL: s.controllerURL, Reachable: true}
+	if checker, ok := s.controllerClient.(healthChecker); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+		defer cancel()
+		if err := checker.CheckHealth(ctx); err != nil {
+			connectivity.Reachable = false
+			connectivity.Error = err.Error()
+		}
+	}
+	return connectivity
+}
+
 // Sensors constructor setters for builder pattern
 
 // SetRPiDevice used by testing to override production RPi interface
@@ -116,6 +431,12 @@ func (s *Sensors) SetRPiDevice(rpiDevice common.RPi) *Sensors {
 	return s
 }
 
+// SetNumFloors set the number of floors in the building, the floor reads a call button for each
+func (s *Sensors) SetNumFloors(numFloors int) *Sensors {
+	s.numFloors = numFloors
+	return s
+}
+
 // SetLoopFrequency used by testing to speed up tests
 func (s *Sensors) SetLoopFrequency(freq time.Duration) *Sensors {
 	s.loopFreq = freq
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common/httpservice"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/floor/api"
+)
+
+var (
+	httpAddrFlag  = flag.String("http_addr", "localhost:9091", "host:port to serve http api on")
+	floorNum      = flag.Int("floor", 1, "the floor this node is on (floors are numbered from 1)")
+	numFloors     = flag.Int("num_floors", 3, "the number of floors the dumbwaiter serves, each has a call button")
+	controllerURL = flag.String("controller_url", "http://localhost:9090", "the controller service's url")
+	pinMapFile    = flag.String("pin_map", "", "json file mapping the floor's pins to gpio lines (default is the standard wiring)")
+	recordFile    = flag.String("record", "", "record the floor's gpio traffic to this file, for replaying later")
+)
+
+// start the service.
+func main() {
+	// parse flags
+	flag.Parse()
+	if *floorNum < 1 || *floorNum > *numFloors {
+		log.Fatalf("floor %d is not one of the %d floors", *floorNum, *numFloors)
+	}
+
+	var piDevice common.RPi
+	piDevice, err := newRPiDevice(*pinMapFile, *numFloors)
+	if err != nil {
+		log.Fatalf("floor startup failed: %v", err)
+	}
+	if *recordFile != "" {
+		f, err := os.Create(*recordFile)
+		if err != nil {
+			log.Fatalf("floor startup failed: %v", err)
+		}
+		defer f.Close()
+		piDevice = common.NewRecordingRPi(piDevice, f)
+	}
+
+	s := newFloorHTTPService(*httpAddrFlag, *floorNum, *numFloors, *controllerURL, piDevice) // create the floor with http nature
+	s.RunService()                                                                           // start the floor listening for requests
+}
+
+// newRPiDevice load the pin map and open just the gpio lines of the floor's sensors and
+// buttons, the inputs are debounced
+func newRPiDevice(pinMapFile string, numFloors int) (*common.DebouncedRPi, error) {
+	pinMap := common.DefaultPinMap()
+	if pinMapFile != "" {
+		var err error
+		if pinMap, err = common.LoadPinMap(pinMapFile); err != nil {
+			return nil, err
+		}
+	}
+	floorPins := common.FloorPins(numFloors)
+	if err := pinMap.Validate(floorPins...); err != nil {
+		return nil, err
+	}
+	piDevice := common.NewRPiDevice(pinMap.Only(floorPins...))
+	if err := piDevice.Open(); err != nil {
+		return nil, err
+	}
+	return common.NewDebouncedRPi(piDevice, common.DefaultDebounceConfig), nil
+}
+
+func newFloorHTTPService(httpAddr string, floorNum int, numFloors int, controllerURL string, piDevice common.RPi) *httpservice.Service {
+	// construct the floor's sensors and start their processing loop
+	sensors := floor.NewSensors(floorNum, controllerURL).SetNumFloors(numFloors).SetRPiDevice(piDevice)
+	sensors.StartProcessingLoop()
+
+	// add the http endpoints
+	httpSensors := api.NewHTTPSensors(sensors)
+
+	// add the final (common) http nature
+	return httpservice.NewService(httpSensors, httpAddr, httpSensors.ServiceName)
+}
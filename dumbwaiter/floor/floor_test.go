@@ -1,13 +1,19 @@
 package floor
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
 )
 
 var testFrequency time.Duration = 500 * time.Millisecond
@@ -17,8 +23,9 @@ var foreverTrueSignal = fakeSignal{signalValue: true, signalEnd: noSignalEnd}
 
 const (
 	lsf = "lastSeenFloor"
+	df  = "departedFloor"
 	rf  = "requestedFloor"
-	sr = "stopRequested"
+	sr  = "stopRequested"
 )
 
 type fakeSignal struct {
@@ -30,9 +37,18 @@ type fakeSignal struct {
 // end time is 0
 type fakePiDevice struct {
 	signals map[common.PiPin]fakeSignal
+	mu      sync.Mutex
+}
+
+func (f *fakePiDevice) setSignals(signals map[common.PiPin]fakeSignal) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.signals = signals
 }
 
 func (f *fakePiDevice) GetSignal(pin common.PiPin) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	if _, ok := f.signals[pin]; !ok {
 		return false, nil
 	}
@@ -46,6 +62,69 @@ func (f *fakePiDevice) SendSignal(pin common.PiPin) error {
 	return nil
 }
 
+// WatchSignal the fake device can only be polled
+func (f *fakePiDevice) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	return nil, common.ErrWatchNotSupported
+}
+
+// eventPiDevice a fake device whose signals are set by the test, each change is sent to the
+// pin's watcher
+type eventPiDevice struct {
+	values   map[common.PiPin]bool
+	watchers map[common.PiPin]chan common.SignalEvent
+	mu       sync.Mutex
+}
+
+func newEventPiDevice() *eventPiDevice {
+	return &eventPiDevice{values: map[common.PiPin]bool{}, watchers: map[common.PiPin]chan common.SignalEvent{}}
+}
+
+// set change the pin's signal, sending an event when it changes
+func (e *eventPiDevice) set(pin common.PiPin, value bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.values[pin] == value {
+		return
+	}
+	e.values[pin] = value
+	edge := common.Falling
+	if value {
+		edge = common.Rising
+	}
+	e.watchers[pin] <- common.SignalEvent{Pin: pin, Edge: edge, Time: time.Now()}
+}
+
+// waitForWatchers wait for the sensors to watch their pins
+func (e *eventPiDevice) waitForWatchers(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		e.mu.Lock()
+		watching := len(e.watchers) == 5
+		e.mu.Unlock()
+		if watching {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the sensors did not watch their pins")
+}
+
+func (e *eventPiDevice) GetSignal(pin common.PiPin) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.values[pin], nil
+}
+
+func (e *eventPiDevice) SendSignal(pin common.PiPin) error {
+	return nil
+}
+
+func (e *eventPiDevice) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.watchers[pin] = make(chan common.SignalEvent, 16)
+	return e.watchers[pin], nil
+}
+
 type controllerCall struct {
 	callType  string
 	callValue int
@@ -58,13 +137,16 @@ type validatingController struct {
 
 	lastSeenFloor  int
 	requestedFloor int
+	mu             sync.Mutex
 }
 
 func newvalidatingController(t *testing.T, expectedSequence []controllerCall) *validatingController {
 	return &validatingController{t: t, expectedSequence: expectedSequence, currentSeqIndex: 0}
 }
 
-func (f *validatingController) SetRequestedFloor(floor int) {
+func (f *validatingController) SetRequestedFloor(ctx context.Context, floor int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	assert.True(f.t, len(f.expectedSequence) > f.currentSeqIndex,
 		fmt.Sprintf("too many calls to SetLastSeenFloor, expected %d got %d", len(f.expectedSequence), f.currentSeqIndex))
 	assert.True(f.t, rf == f.expectedSequence[f.currentSeqIndex].callType,
@@ -75,9 +157,12 @@ func (f *validatingController) SetRequestedFloor(floor int) {
 			f.expectedSequence[f.currentSeqIndex].callValue, floor, f.currentSeqIndex+1))
 	f.currentSeqIndex++
 	f.requestedFloor = floor
+	return nil
 }
 
-func (f *validatingController) SetLastSeenFloor(floor int) {
+func (f *validatingController) SetLastSeenFloor(ctx context.Context, floor int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	assert.True(f.t, len(f.expectedSequence) > f.currentSeqIndex,
 		fmt.Sprintf("too many calls to SetLastSeenFloor, expected %d got %d", len(f.expectedSequence), f.currentSeqIndex))
 	assert.True(f.t, lsf == f.expectedSequence[f.currentSeqIndex].callType, fmt.Sprintf("wrong controller api call, expected %s, got %s (call: %d)",
@@ -87,14 +172,32 @@ func (f *validatingController) SetLastSeenFloor(floor int) {
 			f.expectedSequence[f.currentSeqIndex].callValue, floor, f.currentSeqIndex+1))
 	f.currentSeqIndex++
 	f.lastSeenFloor = floor
+	return nil
+}
+
+func (f *validatingController) SetDepartedFloor(ctx context.Context, floor int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.True(f.t, len(f.expectedSequence) > f.currentSeqIndex,
+		fmt.Sprintf("too many calls to SetDepartedFloor, expected %d got %d", len(f.expectedSequence), f.currentSeqIndex))
+	assert.True(f.t, df == f.expectedSequence[f.currentSeqIndex].callType, fmt.Sprintf("wrong controller api call, expected %s, got %s (call: %d)",
+		f.expectedSequence[f.currentSeqIndex].callType, df, f.currentSeqIndex+1))
+	assert.True(f.t, f.expectedSequence[f.currentSeqIndex].callValue == floor,
+		fmt.Sprintf("wrong floor number, expected %d got %d (call:%d)",
+			f.expectedSequence[f.currentSeqIndex].callValue, floor, f.currentSeqIndex+1))
+	f.currentSeqIndex++
+	return nil
 }
 
-func (f *validatingController) SetStopRequested() {
+func (f *validatingController) SetStopRequested(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	assert.True(f.t, len(f.expectedSequence) > f.currentSeqIndex,
 		fmt.Sprintf("too many calls to SetStopRequested, expected %d got %d", len(f.expectedSequence), f.currentSeqIndex))
 	assert.True(f.t, sr == f.expectedSequence[f.currentSeqIndex].callType, fmt.Sprintf("wrong controller api call, expected %s, got %s (call: %d)",
 		f.expectedSequence[f.currentSeqIndex].callType, sr, f.currentSeqIndex+1))
 	f.currentSeqIndex++
+	return nil
 }
 
 func TestArriveAtFloor(t *testing.T) {
@@ -113,7 +216,7 @@ func TestArriveAtFloor(t *testing.T) {
 		common.StopRequested:   foreverFalseSignal}
 
 	// test
-	mockRPi.signals = signals // this action triggers the test
+	mockRPi.setSignals(signals) // this action triggers the test
 
 	// final validation
 	waitForStatus(t, 1, 0, controllerClient, 1*time.Second)
@@ -135,7 +238,7 @@ func TestPressFloor1Button(t *testing.T) {
 		common.StopRequested:   foreverFalseSignal}
 
 	// test
-	mockRPi.signals = signals // this action triggers the test
+	mockRPi.setSignals(signals) // this action triggers the test
 
 	// final validation
 	waitForStatus(t, 0, 1, controllerClient, 1*time.Second)
@@ -157,25 +260,437 @@ func TestPressStopButton(t *testing.T) {
 		common.StopRequested:   foreverTrueSignal}
 
 	// test
-	mockRPi.signals = signals // this action triggers the test
+	mockRPi.setSignals(signals) // this action triggers the test
 
 	// final validation
 	waitForStatus(t, 0, 0, controllerClient, 1*time.Second)
 }
 
+// TestPressFloor2ButtonEvent the sensors run off the RPi's events when it can be watched
+func TestPressFloor2ButtonEvent(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "floor1RPi", []common.PiPin{common.Floor2Requested})
+	controllerClient := newvalidatingController(t, []controllerCall{controllerCall{callType: rf, callValue: 2}})
+	// a loop frequency this slow would miss the press if the sensors were polled
+	sensors := NewSensors(1, "fakeURL").SetRPiDevice(mockRPi).SetControllerClient(controllerClient).SetLoopFrequency(time.Hour)
+	sensors.StartProcessingLoop()
+	time.Sleep(100 * time.Millisecond) // let the loop start watching
+
+	// test
+	mockRPi.SendSignal(common.Floor2Requested) // emits the button's rising edge
+
+	// final validation
+	waitForStatus(t, 0, 2, controllerClient, 1*time.Second)
+}
+
+// TestPressFloor4Button a four floor building has a call button for floor 4
+func TestPressFloor4Button(t *testing.T) {
+	// setup
+	mockRPi := common.NewMockRPi(t, "floor1RPi", []common.PiPin{common.FloorRequested(4)})
+	controllerClient := newvalidatingController(t, []controllerCall{controllerCall{callType: rf, callValue: 4}})
+	sensors := NewSensors(1, "fakeURL").SetNumFloors(4).SetRPiDevice(mockRPi).SetControllerClient(controllerClient).SetLoopFrequency(time.Hour)
+	sensors.StartProcessingLoop()
+	time.Sleep(100 * time.Millisecond) // let the loop start watching
+
+	// test
+	mockRPi.SendSignal(common.FloorRequested(4))
+
+	// final validation
+	waitForStatus(t, 0, 4, controllerClient, 1*time.Second)
+}
+
+// TestRepeatedFloorButtonPresses a call is sent for every press of a button, holding it down
+// only sends one
+func TestRepeatedFloorButtonPresses(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := newvalidatingController(t, []controllerCall{{callType: rf, callValue: 2}, {callType: rf, callValue: 2}, {callType: rf, callValue: 3}})
+	NewSensors(1, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor2Requested, true)
+	rpi.set(common.Floor2Requested, false)
+	rpi.set(common.Floor2Requested, true)
+	waitForCallCount(t, controllerClient, 2, 1*time.Second) // the buttons' events aren't ordered with each other
+	rpi.set(common.Floor3Requested, true)                   // floor 2's button is still held
+	rpi.set(common.Floor3Requested, true)
+
+	// final validation
+	waitForCalls(t, controllerClient, 1*time.Second)
+}
+
+// TestRepeatedStopPresses every press of the stop button sends a stop
+func TestRepeatedStopPresses(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := newvalidatingController(t, []controllerCall{{callType: sr}, {callType: sr}})
+	NewSensors(1, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.StopRequested, true)
+	rpi.set(common.StopRequested, false)
+	rpi.set(common.StopRequested, true)
+
+	// final validation
+	waitForCalls(t, controllerClient, 1*time.Second)
+}
+
+// TestRoundTrip the car's arrival is sent every time it comes back to the floor, and its
+// departure every time it leaves
+func TestRoundTrip(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := newvalidatingController(t, []controllerCall{{callType: lsf, callValue: 2}, {callType: df, callValue: 2},
+		{callType: lsf, callValue: 2}, {callType: df, callValue: 2}, {callType: lsf, callValue: 2}})
+	NewSensors(2, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	for i := 0; i < 2; i++ {
+		rpi.set(common.AtFloor, true)
+		rpi.set(common.AtFloor, false)
+	}
+	rpi.set(common.AtFloor, true)
+
+	// final validation
+	waitForCalls(t, controllerClient, 1*time.Second)
+}
+
+// TestPolledRoundTrip polled sensors also send every arrival and departure
+func TestPolledRoundTrip(t *testing.T) {
+	// setup
+	mockRPi := &fakePiDevice{}
+	controllerClient := newvalidatingController(t, []controllerCall{{callType: lsf, callValue: 1}, {callType: df, callValue: 1}, {callType: lsf, callValue: 1}})
+	NewSensors(1, "fakeURL").SetRPiDevice(mockRPi).SetControllerClient(controllerClient).SetLoopFrequency(10 * time.Millisecond).StartProcessingLoop()
+
+	// test
+	mockRPi.setSignals(map[common.PiPin]fakeSignal{common.AtFloor: {signalValue: true, signalEnd: time.Now().Add(200 * time.Millisecond)}})
+	time.Sleep(400 * time.Millisecond)
+	mockRPi.setSignals(map[common.PiPin]fakeSignal{common.AtFloor: foreverTrueSignal})
+
+	// final validation
+	waitForCalls(t, controllerClient, 1*time.Second)
+}
+
+// TestStatus the status shows the held buttons, the sensor and the commands sent
+func TestStatus(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := newvalidatingController(t, []controllerCall{{callType: lsf, callValue: 2}, {callType: rf, callValue: 3}})
+	sensors := NewSensors(2, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.AtFloor, true)
+	waitForCallCount(t, controllerClient, 1, 1*time.Second)
+	rpi.set(common.Floor3Requested, true)
+	waitForCalls(t, controllerClient, 1*time.Second)
+
+	// final validation
+	status := sensors.GetStatus()
+	assert.Equal(t, 2, status.FloorNum)
+	assert.True(t, status.AtFloor)
+	assert.False(t, status.StopPressed)
+	assert.Equal(t, []int{3}, status.CallsPressed)
+	assert.Len(t, status.LastCommands, 2)
+	assert.Equal(t, Command{Time: status.LastCommands[1].Time, Name: "SetRequestedFloor", Floor: 3}, status.LastCommands[1])
+}
+
+// TestCheckController the floor reports whether the controller's service answers
+func TestCheckController(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/controller/health", r.URL.Path)
+	}))
+	defer up.Close()
+	connectivity := NewSensors(1, up.URL).CheckController()
+	assert.True(t, connectivity.Reachable)
+	assert.Equal(t, up.URL, connectivity.ControllerURL)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	connectivity = NewSensors(1, down.URL).CheckController()
+	assert.False(t, connectivity.Reachable)
+	assert.NotEmpty(t, connectivity.Error)
+}
+
+// homingServer a controller's http service that is homing the car until a floor reports it,
+// calls are refused with position_unknown until then
+type homingServer struct {
+	found    bool
+	requests []string
+	mu       sync.Mutex
+}
+
+func (h *homingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests = append(h.requests, r.URL.Path)
+	if strings.HasPrefix(r.URL.Path, "/controller/last_seen_floor/") {
+		h.found = true
+	}
+	if strings.HasPrefix(r.URL.Path, "/controller/requested_floor/") && !h.found {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"Error": "car position unknown: the car is being homed", "Code": "position_unknown"}`))
+	}
+}
+
+func (h *homingServer) getRequests() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.requests...)
+}
+
+// TestCallWhileHomingOverHTTP a call the controller's service refuses while homing isn't
+// retried as if the controller were unreachable, the arrival that finds the car is sent
+// before it
+func TestCallWhileHomingOverHTTP(t *testing.T) {
+	// setup
+	controllerService := &homingServer{}
+	server := httptest.NewServer(controllerService)
+	defer server.Close()
+	rpi := newEventPiDevice()
+	sensors := NewSensors(2, server.URL).SetRPiDevice(rpi).SetLoopFrequency(200 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor3Requested, true)
+	time.Sleep(50 * time.Millisecond)
+	rpi.set(common.AtFloor, true)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"/controller/requested_floor/3", "/controller/last_seen_floor/2"}, controllerService.getRequests())
+
+	// final validation
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, []string{"/controller/requested_floor/3", "/controller/last_seen_floor/2", "/controller/requested_floor/3"}, controllerService.getRequests())
+	assert.Equal(t, 0, sensors.GetStatus().PendingCommands)
+}
+
+// erroringController a controller whose calls fail with the scripted errors, in order, then
+// succeed
+type erroringController struct {
+	errs  []error
+	calls []string
+	mu    sync.Mutex
+}
+
+func (e *erroringController) call(name string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, name)
+	if len(e.errs) == 0 {
+		return nil
+	}
+	err := e.errs[0]
+	e.errs = e.errs[1:]
+	return err
+}
+
+func (e *erroringController) SetRequestedFloor(ctx context.Context, floor int) error {
+	return e.call(fmt.Sprintf("%s %d", rf, floor))
+}
+
+func (e *erroringController) SetLastSeenFloor(ctx context.Context, floor int) error {
+	return e.call(fmt.Sprintf("%s %d", lsf, floor))
+}
+
+func (e *erroringController) SetDepartedFloor(ctx context.Context, floor int) error {
+	return e.call(fmt.Sprintf("%s %d", df, floor))
+}
+
+func (e *erroringController) SetStopRequested(ctx context.Context) error {
+	return e.call(sr)
+}
+
+func (e *erroringController) getCalls() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.calls...)
+}
+
+// TestRetryUnreachableController commands that can't reach the controller are retried, in
+// order, and commands after them wait their turn
+func TestRetryUnreachableController(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	unreachable := fmt.Errorf("PUT: %w", api.ErrControllerUnreachable)
+	controllerClient := &erroringController{errs: []error{unreachable, unreachable, unreachable}}
+	sensors := NewSensors(2, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).SetLoopFrequency(200 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.AtFloor, true)
+	time.Sleep(20 * time.Millisecond)
+	rpi.set(common.Floor3Requested, true)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 2, sensors.GetStatus().PendingCommands)
+
+	// final validation
+	time.Sleep(600 * time.Millisecond)
+	assert.Equal(t, []string{"lastSeenFloor 2", "lastSeenFloor 2", "lastSeenFloor 2", "lastSeenFloor 2", "requestedFloor 3"}, controllerClient.getCalls())
+	status := sensors.GetStatus()
+	assert.Equal(t, 0, status.PendingCommands)
+	assert.Equal(t, "", status.Alert)
+}
+
+// TestControllerFaultAlert a controller in fault raises the floor's alert, the command is not
+// retried and the alert clears when a command succeeds
+func TestControllerFaultAlert(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := &erroringController{errs: []error{fmt.Errorf("PUT: %w", api.ErrControllerFault)}}
+	sensors := NewSensors(1, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).SetLoopFrequency(10 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor2Requested, true)
+	time.Sleep(100 * time.Millisecond)
+	status := sensors.GetStatus()
+	assert.Contains(t, status.Alert, "controller in fault")
+	assert.Equal(t, 0, status.PendingCommands)
+	assert.Equal(t, []string{"requestedFloor 2"}, controllerClient.getCalls())
+
+	// final validation
+	rpi.set(common.StopRequested, true)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "", sensors.GetStatus().Alert)
+}
+
+// TestCallWhileHoming a call made while the controller is looking for the car is retried, and
+// doesn't hold up the arrival that finds the car
+func TestCallWhileHoming(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	homing := fmt.Errorf("PUT: %w", api.ErrPositionUnknown)
+	controllerClient := &erroringController{errs: []error{homing}}
+	sensors := NewSensors(2, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).SetLoopFrequency(200 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor3Requested, true)
+	time.Sleep(20 * time.Millisecond)
+	rpi.set(common.AtFloor, true)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, []string{"requestedFloor 3", "lastSeenFloor 2"}, controllerClient.getCalls())
+
+	// final validation
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, []string{"requestedFloor 3", "lastSeenFloor 2", "requestedFloor 3"}, controllerClient.getCalls())
+	assert.Equal(t, 0, sensors.GetStatus().PendingCommands)
+}
+
+// TestInvalidFloorDropped a call for a floor the controller doesn't have is dropped
+func TestInvalidFloorDropped(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := &erroringController{errs: []error{fmt.Errorf("PUT: %w", api.ErrInvalidFloor)}}
+	sensors := NewSensors(1, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).SetLoopFrequency(10 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor3Requested, true)
+	time.Sleep(100 * time.Millisecond)
+
+	// final validation
+	status := sensors.GetStatus()
+	assert.Equal(t, []string{"requestedFloor 3"}, controllerClient.getCalls())
+	assert.Equal(t, 0, status.PendingCommands)
+	assert.Equal(t, "", status.Alert)
+	assert.Contains(t, status.LastCommands[0].Error, "invalid floor")
+}
+
+// hangingController a controller whose calls succeed, except for SetRequestedFloor which
+// doesn't answer until released
+type hangingController struct {
+	erroringController
+	release chan struct{}
+}
+
+func (h *hangingController) SetRequestedFloor(ctx context.Context, floor int) error {
+	err := h.call(fmt.Sprintf("%s %d", rf, floor))
+	select {
+	case <-h.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// TestStopNotHeldUp a stop is sent while an earlier call is still waiting on the controller,
+// and the sensors are read meanwhile
+func TestStopNotHeldUp(t *testing.T) {
+	// setup
+	rpi := newEventPiDevice()
+	controllerClient := &hangingController{release: make(chan struct{})}
+	defer close(controllerClient.release)
+	sensors := NewSensors(1, "fakeURL").SetRPiDevice(rpi).SetControllerClient(controllerClient).SetLoopFrequency(10 * time.Millisecond)
+	sensors.StartProcessingLoop()
+	rpi.waitForWatchers(t)
+
+	// test
+	rpi.set(common.Floor2Requested, true)
+	time.Sleep(20 * time.Millisecond)
+	rpi.set(common.StopRequested, true)
+	time.Sleep(100 * time.Millisecond)
+
+	// final validation
+	assert.Equal(t, []string{"requestedFloor 2", "stopRequested"}, controllerClient.getCalls())
+	status := sensors.GetStatus()
+	assert.True(t, status.StopPressed)
+	assert.Equal(t, 1, status.PendingCommands)
+}
+
+// waitForCalls wait for the controller to get all of its expected calls
+func waitForCalls(t *testing.T, dwc *validatingController, timeout time.Duration) {
+	waitForCallCount(t, dwc, len(dwc.expectedSequence), timeout)
+	time.Sleep(100 * time.Millisecond) // catch any extra calls
+}
+
+// waitForCallCount wait for the controller to get its first n expected calls
+func waitForCallCount(t *testing.T, dwc *validatingController, n int, timeout time.Duration) {
+	waitTill := time.Now().Add(timeout)
+	for time.Now().Before(waitTill) {
+		dwc.mu.Lock()
+		calls := dwc.currentSeqIndex
+		dwc.mu.Unlock()
+		if calls >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dwc.mu.Lock()
+	defer dwc.mu.Unlock()
+	assert.Fail(t, fmt.Sprintf("timeout: expected %d controller calls, got %d", n, dwc.currentSeqIndex))
+}
+
+// floors get the last seen and requested floors the controller was sent
+func (f *validatingController) floors() (lastSeenFloor int, requestedFloor int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastSeenFloor, f.requestedFloor
+}
+
 func waitForStatus(t *testing.T, lastSeenFloor int, requestedFloor int, dwc *validatingController, timeout time.Duration) {
 	waitTill := time.Now().Add(timeout)
 	time.Sleep(500 * time.Millisecond)
 	for time.Now().Before(waitTill) {
-		if lastSeenFloor == dwc.lastSeenFloor && requestedFloor == dwc.requestedFloor {
+		if gotLastSeen, gotRequested := dwc.floors(); lastSeenFloor == gotLastSeen && requestedFloor == gotRequested {
 			return
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
+	gotLastSeen, gotRequested := dwc.floors()
 	if requestedFloor != 0 {
-		assert.Equal(t, requestedFloor, dwc.requestedFloor, fmt.Sprintf("wrong requested floor, expected %d got %d", requestedFloor, dwc.requestedFloor))
+		assert.Equal(t, requestedFloor, gotRequested, fmt.Sprintf("wrong requested floor, expected %d got %d", requestedFloor, gotRequested))
 	}
 	if lastSeenFloor != 0 {
-		assert.Equal(t, lastSeenFloor, dwc.lastSeenFloor, fmt.Sprintf("wrong last seen floor, expected %d, got %d", lastSeenFloor, dwc.lastSeenFloor))
+		assert.Equal(t, lastSeenFloor, gotLastSeen, fmt.Sprintf("wrong last seen floor, expected %d, got %d", lastSeenFloor, gotLastSeen))
 	}
 }
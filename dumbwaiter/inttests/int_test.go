@@ -16,6 +16,7 @@ import (
 
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
 	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
 	floor_sensors "github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
 )
 
@@ -56,11 +57,30 @@ func TestPlatformArrivesAtRequestedFloor(t *testing.T) {
 	waitForControllerStatus(t, 3, 3, controller.Stopped, dwc, 5*time.Second)
 }
 
+// testFrequency speed up tests
+const testFrequency = 50 * time.Millisecond
+
 // setup creates a controller and sensors, each with their own mock pi interface
 func setup(t *testing.T, floor int, direction controller.Direction) (*controller.Controller, *common.MockRPi, []*floor_sensors.Sensors, []*common.MockRPi) {
-	testFrequency := 50 * time.Millisecond // speed up tests
+	dwController, controlMockRPi := setupController(t, floor, direction)
+
+	var mockRPis [3]*common.MockRPi
+	var floors [3]*floor_sensors.Sensors
+	// set up floor sensors
+	for i := 0; i < 3; i++ {
+		mockRPis[i] = common.NewMockRPi(t, fmt.Sprintf("floor%dRPi", i+1), nil)
+		floors[i] = floor_sensors.NewSensors(i+1, "fakeURL").
+			SetRPiDevice(mockRPis[i]).
+			SetControllerClient(api.NewInProcessController(dwController)).
+			SetLoopFrequency(testFrequency)
+		floors[i].StartProcessingLoop()
+	}
+
+	return dwController, controlMockRPi, floors[:], mockRPis[:]
+}
 
-	// set up the controller
+// setupController creates a running controller with a mock pi interface, the car is at floor
+func setupController(t *testing.T, floor int, direction controller.Direction) (*controller.Controller, *common.MockRPi) {
 	controlMockRPi := common.NewMockRPi(t, "controllerRPi", nil)
 	var dwController *controller.Controller
 	dwController = controller.NewController(3).SetRPiDevice(controlMockRPi).SetLoopFrequency(testFrequency)
@@ -74,20 +94,7 @@ func setup(t *testing.T, floor int, direction controller.Direction) (*controller
 		dwController.SetRequestedFloor(floor - 1)
 	}
 	dwController.StartProcessingLoop()
-
-	var mockRPis [3]*common.MockRPi
-	var floors [3]*floor_sensors.Sensors
-	// set up floor sensors
-	for i := 0; i < 3; i++ {
-		mockRPis[i] = common.NewMockRPi(t, fmt.Sprintf("floor%dRPi", i+1), nil)
-		floors[i] = floor_sensors.NewSensors(i+1, "fakeURL").
-			SetRPiDevice(mockRPis[i]).
-			SetControllerClient(dwController).
-			SetLoopFrequency(testFrequency)
-		floors[i].StartProcessingLoop()
-	}
-
-	return dwController, controlMockRPi, floors[:], mockRPis[:]
+	return dwController, controlMockRPi
 }
 
 func waitForControllerStatus(t *testing.T, lastSeenFloor int, requestedFloor int, expectedDirection controller.Direction, dwc *controller.Controller, timeout time.Duration) {
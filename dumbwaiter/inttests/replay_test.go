@@ -0,0 +1,52 @@
+package inttests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
+	floor_sensors "github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+)
+
+// TestReplayFloor2CallsFloor3 replay a recording of floor 2's RPi while the car, parked at
+// floor 1, is called to floor 3 from floor 2 and passes floor 2 on its way up.  The controller
+// should be moving up having last seen the car at floor 2.
+func TestReplayFloor2CallsFloor3(t *testing.T) {
+	records, err := common.LoadRecording("testdata/floor2_calls_floor3.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := common.NewReplayRPi(records, 20)
+
+	dwc, dwcRpi := setupController(t, 1, controller.Stopped)
+	dwcRpi.ExpectedCalls = []common.PiPin{common.OpenerUp}
+	floor_sensors.NewSensors(2, "fakeURL").SetRPiDevice(replay).SetControllerClient(api.NewInProcessController(dwc)).StartProcessingLoop()
+
+	<-replay.Done()
+	waitForControllerStatus(t, 2, 3, controller.Up, dwc, 5*time.Second)
+}
+
+// TestReplayControllerEmergencyStop replay a recording of the controller's RPi while the car,
+// called from floor 1 to floor 3, is emergency stopped on its way up.  The replayed controller
+// should send the same signals, the stop preempting the opener's relays.
+func TestReplayControllerEmergencyStop(t *testing.T) {
+	records, err := common.LoadRecording("testdata/controller_estop_moving_up.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := common.NewReplayRPi(records, 20)
+
+	dwc := controller.NewController(3).SetRPiDevice(replay).SetLoopFrequency(testFrequency)
+	dwc.SetLastSeenFloor(1)
+	dwc.StartProcessingLoop()
+	assert.NoError(t, dwc.SetRequestedFloor(3))
+	waitForControllerStatus(t, 1, 3, controller.Up, dwc, 5*time.Second)
+	assert.NoError(t, dwc.EmergencyStop("floor 2"))
+
+	assert.Equal(t, controller.EmergencyStopped, dwc.GetState())
+	assert.Equal(t, replay.RecordedSends(), replay.Sent())
+}
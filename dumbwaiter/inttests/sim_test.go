@@ -0,0 +1,145 @@
+package inttests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/controller/api"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/drive"
+	floor_sensors "github.com/JeanetteBruno/jbruno/dumbwaiter/floor"
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/sim"
+)
+
+// TestSimulatedShaftRoundTrip call the car from floor 1 to floor 3 and back in a simulated
+// shaft, the floors only learn where the car is from their simulated AtFloor sensors
+func TestSimulatedShaftRoundTrip(t *testing.T) {
+	shaft, dwc, config, stop := setupSimulation(t)
+	defer close(stop)
+
+	assert.NoError(t, shaft.PressButton(1, common.Floor3Requested))
+	waitForSimulatedStatus(t, 3, controller.Stopped, dwc)
+	assert.InDelta(t, 3.0, shaft.Position(), config.SensorWidth/2)
+
+	assert.NoError(t, shaft.PressButton(3, common.Floor1Requested))
+	waitForSimulatedStatus(t, 1, controller.Stopped, dwc)
+	assert.InDelta(t, 1.0, shaft.Position(), config.SensorWidth/2)
+
+	// call it back up again from the same button
+	assert.NoError(t, shaft.PressButton(1, common.Floor3Requested))
+	waitForSimulatedStatus(t, 3, controller.Stopped, dwc)
+}
+
+// TestSimulatedStall a stalled motor faults the controller, which stops it and refuses calls
+// until the fault is reset
+func TestSimulatedStall(t *testing.T) {
+	shaft, dwc, _, stop := setupSimulation(t)
+	defer close(stop)
+
+	shaft.SetStalled(true)
+	assert.NoError(t, shaft.PressButton(1, common.Floor3Requested))
+	waitTill := time.Now().Add(5 * time.Second)
+	for time.Now().Before(waitTill) && dwc.GetState() != controller.Fault {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := dwc.GetStatus()
+	assert.Equal(t, controller.Fault, status.State)
+	assert.Equal(t, controller.FaultStall, status.Fault.Reason)
+	assert.Equal(t, "stopped", shaft.GetStatus().Motor)
+
+	shaft.SetStalled(false)
+	assert.NoError(t, dwc.ResetFault())
+	waitForSimulatedStatus(t, 3, controller.Stopped, dwc)
+}
+
+// TestSimulatedOverTravel a car that isn't seen at the top floor trips the top limit switch,
+// the controller cuts the drive and faults
+func TestSimulatedOverTravel(t *testing.T) {
+	shaft, dwc, config, stop := setupSimulation(t)
+	defer close(stop)
+
+	assert.NoError(t, shaft.SetMissingSensor(3, true))
+	assert.NoError(t, shaft.PressButton(1, common.Floor3Requested))
+	waitTill := time.Now().Add(5 * time.Second)
+	for time.Now().Before(waitTill) && dwc.GetState() != controller.Fault {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := dwc.GetStatus()
+	assert.Equal(t, controller.Fault, status.State)
+	assert.Equal(t, controller.FaultOverTravel, status.Fault.Reason)
+	assert.True(t, status.TopLimit)
+	assert.Equal(t, "stopped", shaft.GetStatus().Motor)
+	assert.Less(t, shaft.Position(), 3+config.OverTravel, "the car is stopped before the end of the shaft")
+}
+
+// TestSimulatedHoming a car that starts between floors is homed down to the floor below
+func TestSimulatedHoming(t *testing.T) {
+	config := sim.DefaultConfig
+	config.FloorsPerSec = 5
+	config.TickInterval = time.Millisecond
+	config.StartFloor = 2.5
+	shaft := sim.NewShaft(config)
+	stop := make(chan struct{})
+	defer close(stop)
+	go shaft.Run(stop)
+
+	piDevice := shaft.ControllerRPi()
+	dwc := controller.NewController(3).SetRPiDevice(piDevice).SetDrive(drive.NewThreeRelayOpener(piDevice)).SetTimeToMoveOneFloor(time.Second).SetLoopFrequency(10 * time.Millisecond)
+	dwc.StartProcessingLoop()
+	for i := 1; i <= 3; i++ {
+		floor_sensors.NewSensors(i, "fakeURL").SetRPiDevice(shaft.FloorRPi(i)).SetControllerClient(api.NewInProcessController(dwc)).StartProcessingLoop()
+	}
+
+	waitTill := time.Now().Add(5 * time.Second)
+	for time.Now().Before(waitTill) && (dwc.GetHoming() == nil || dwc.GetHoming().Phase != controller.HomingDone) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := dwc.GetStatus()
+	assert.Equal(t, controller.HomingDone, status.Homing.Phase)
+	assert.Equal(t, 2, status.LastSeenFloor)
+	assert.Equal(t, "stopped", shaft.GetStatus().Motor)
+	assert.InDelta(t, 2.0, shaft.Position(), 0.1)
+
+	assert.NoError(t, shaft.PressButton(2, common.Floor3Requested))
+	waitForSimulatedStatus(t, 3, controller.Stopped, dwc)
+}
+
+// setupSimulation a fast simulated shaft with the car parked at floor 1, its controller (with
+// a short travel time limit) and floors
+func setupSimulation(t *testing.T) (*sim.Shaft, *controller.Controller, sim.Config, chan struct{}) {
+	config := sim.DefaultConfig
+	config.FloorsPerSec = 5
+	config.TickInterval = time.Millisecond
+	shaft := sim.NewShaft(config)
+	stop := make(chan struct{})
+	go shaft.Run(stop)
+
+	piDevice := shaft.ControllerRPi()
+	dwc := controller.NewController(3).SetRPiDevice(piDevice).SetDrive(drive.NewThreeRelayOpener(piDevice)).SetTimeToMoveOneFloor(time.Second).
+		SetLimitSwitches(true).SetLoopFrequency(10 * time.Millisecond)
+	dwc.SetRequestedFloor(1) // the car is parked at floor 1
+	dwc.StartProcessingLoop()
+	for i := 1; i <= 3; i++ {
+		floor_sensors.NewSensors(i, "fakeURL").SetRPiDevice(shaft.FloorRPi(i)).SetControllerClient(api.NewInProcessController(dwc)).StartProcessingLoop()
+	}
+	waitForSimulatedStatus(t, 1, controller.Stopped, dwc)
+	return shaft, dwc, config, stop
+}
+
+func waitForSimulatedStatus(t *testing.T, floor int, expectedDirection controller.Direction, dwc *controller.Controller) {
+	waitTill := time.Now().Add(5 * time.Second)
+	for time.Now().Before(waitTill) {
+		s := dwc.GetStatus()
+		if expectedDirection == s.MovingDirection && floor == s.LastSeenFloor && floor == s.RequestedFloor {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s := dwc.GetStatus()
+	assert.Equal(t, expectedDirection.String(), s.MovingDirection.String(), "timeout: wrong direction")
+	assert.Equal(t, floor, s.LastSeenFloor, "timeout: wrong last seen floor")
+	assert.Equal(t, floor, s.RequestedFloor, "timeout: wrong requested floor")
+}
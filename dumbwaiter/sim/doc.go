@@ -0,0 +1,2 @@
+//Package sim sim package implements a simulated dumbwaiter shaft that stands in for the controller's and floors' RPi devices, so the whole system can run without hardware.
+package sim
@@ -0,0 +1,123 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// HTTPShaft is the structure for adding the simulated shaft's restian endpoints, they press
+// the floors' buttons and inject faults
+type HTTPShaft struct {
+	Shaft       *Shaft
+	ServiceName string
+}
+
+// NewHTTPShaft wrap the simulated shaft with http entrypoints
+func NewHTTPShaft(shaft *Shaft) *HTTPShaft {
+	return &HTTPShaft{Shaft: shaft, ServiceName: "sim"}
+}
+
+// AddEndpoints adds the http endpoints to the server
+func (h *HTTPShaft) AddEndpoints(router *mux.Router) {
+	log.Info("adding simulated shaft endpoints")
+	router.HandleFunc(fmt.Sprintf("/%s/status", h.ServiceName), h.StatusEndpoint).Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/%s/floors/{floor}/buttons/{pin}/press", h.ServiceName), h.PressEndpoint).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/%s/floors/{floor}/buttons/{pin}/stuck", h.ServiceName), h.StuckButtonEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/floors/{floor}/sensor/missing", h.ServiceName), h.MissingSensorEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/motor/stalled", h.ServiceName), h.StalledEndpoint).Methods("PUT")
+	router.HandleFunc(fmt.Sprintf("/%s/motor/speed", h.ServiceName), h.SpeedEndpoint).Methods("PUT")
+}
+
+// StatusEndpoint implement the http entry for simulated shaft status requests
+func (h *HTTPShaft) StatusEndpoint(w http.ResponseWriter, r *http.Request) {
+	h.writeStatus(w)
+}
+
+// PressEndpoint press a floor's button, e.g. POST /sim/floors/1/buttons/Floor3Requested/press
+func (h *HTTPShaft) PressEndpoint(w http.ResponseWriter, r *http.Request) {
+	floor, pin, err := floorButton(r)
+	if err == nil {
+		err = h.Shaft.PressButton(floor, pin)
+	}
+	h.respond(w, err)
+}
+
+// StuckButtonEndpoint stick (value=true) or free (value=false) a floor's button
+func (h *HTTPShaft) StuckButtonEndpoint(w http.ResponseWriter, r *http.Request) {
+	floor, pin, err := floorButton(r)
+	var stuck bool
+	if err == nil {
+		stuck, err = strconv.ParseBool(r.URL.Query().Get("value"))
+	}
+	if err == nil {
+		err = h.Shaft.SetStuckButton(floor, pin, stuck)
+	}
+	h.respond(w, err)
+}
+
+// MissingSensorEndpoint break (value=true) or fix (value=false) a floor's AtFloor sensor
+func (h *HTTPShaft) MissingSensorEndpoint(w http.ResponseWriter, r *http.Request) {
+	floor, err := strconv.Atoi(mux.Vars(r)["floor"])
+	var missing bool
+	if err == nil {
+		missing, err = strconv.ParseBool(r.URL.Query().Get("value"))
+	}
+	if err == nil {
+		err = h.Shaft.SetMissingSensor(floor, missing)
+	}
+	h.respond(w, err)
+}
+
+// StalledEndpoint stall (value=true) or free (value=false) the motor
+func (h *HTTPShaft) StalledEndpoint(w http.ResponseWriter, r *http.Request) {
+	stalled, err := strconv.ParseBool(r.URL.Query().Get("value"))
+	if err == nil {
+		h.Shaft.SetStalled(stalled)
+	}
+	h.respond(w, err)
+}
+
+// SpeedEndpoint run the motor at a fraction (factor) of its normal speed
+func (h *HTTPShaft) SpeedEndpoint(w http.ResponseWriter, r *http.Request) {
+	factor, err := strconv.ParseFloat(r.URL.Query().Get("factor"), 64)
+	if err == nil {
+		h.Shaft.SetSpeedFactor(factor)
+	}
+	h.respond(w, err)
+}
+
+// respond return the error, or the shaft's status when the request worked
+func (h *HTTPShaft) respond(w http.ResponseWriter, err error) {
+	if err != nil {
+		log.Warnf("simulated shaft request failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.writeStatus(w)
+}
+
+func (h *HTTPShaft) writeStatus(w http.ResponseWriter) {
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Shaft.GetStatus())
+}
+
+// floorButton the floor and button named in the request's path
+func floorButton(r *http.Request) (int, common.PiPin, error) {
+	vars := mux.Vars(r)
+	floor, err := strconv.Atoi(vars["floor"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid floor %q", vars["floor"])
+	}
+	pin, err := common.ParsePiPin(vars["pin"])
+	if err != nil {
+		return 0, 0, err
+	}
+	return floor, pin, nil
+}
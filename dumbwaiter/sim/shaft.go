@@ -0,0 +1,424 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// DefaultConfig a three floor shaft with a car that takes four seconds per floor
+var DefaultConfig = Config{
+	NumFloors:     3,
+	FloorsPerSec:  0.25,
+	SensorWidth:   0.05,
+	OverTravel:    0.2,
+	StartFloor:    1,
+	TickInterval:  10 * time.Millisecond,
+	PressDuration: 200 * time.Millisecond,
+}
+
+// Config the shape of the simulated shaft
+type Config struct {
+	NumFloors     int           // floors in the shaft, numbered from 1
+	FloorsPerSec  float64       // the car's speed at full motor speed
+	SensorWidth   float64       // how much of a floor's travel (centered on the floor) its AtFloor sensor is on for
+	OverTravel    float64       // how far past the end floors the shaft goes, the limit switches trip half way there (0 for none)
+	StartFloor    float64       // where the car starts
+	TickInterval  time.Duration // how often the car is moved when the shaft runs on its own
+	PressDuration time.Duration // how long a pressed button stays on
+}
+
+// motorState what the opener's motor is doing
+type motorState int
+
+const (
+	motorStopped motorState = iota
+	motorUp
+	motorDown
+)
+
+func (m motorState) String() string {
+	return [...]string{"stopped", "up", "down"}[m]
+}
+
+// Status the simulated shaft's state
+type Status struct {
+	Position       float64 // the car's position in floors
+	Motor          string
+	Stalled        bool
+	SpeedFactor    float64
+	MissingSensors []int
+	StuckButtons   []string
+}
+
+// Shaft simulates a car moved by a three relay garage door opener past an AtFloor sensor on
+// each floor.  The controller's RPi drives the opener and reads the limit switches past the
+// end floors, each floor's RPi reads its sensor and buttons.  Faults can be injected: a stalled motor, a slow motor, missing sensor pulses
+// and stuck buttons.
+type Shaft struct {
+	config   Config
+	position float64
+	motor    motorState
+
+	stalled        bool
+	speedFactor    float64
+	missingSensors map[int]bool
+	stuck          map[int]map[common.PiPin]bool
+	releaseAt      map[int]map[common.PiPin]time.Duration // when pressed buttons are released
+	elapsed        time.Duration                          // simulated time since the start
+
+	controller *ControllerRPi
+	floors     []*FloorRPi
+	mu         sync.Mutex
+}
+
+// NewShaft create a simulated shaft
+func NewShaft(config Config) *Shaft {
+	s := &Shaft{
+		config:         config,
+		position:       config.StartFloor,
+		speedFactor:    1,
+		missingSensors: map[int]bool{},
+		stuck:          map[int]map[common.PiPin]bool{},
+		releaseAt:      map[int]map[common.PiPin]time.Duration{},
+	}
+	s.controller = &ControllerRPi{shaft: s}
+	for floor := 1; floor <= config.NumFloors; floor++ {
+		s.floors = append(s.floors, &FloorRPi{shaft: s, floor: floor, watchers: map[common.PiPin][]chan common.SignalEvent{}, last: map[common.PiPin]bool{}})
+		s.stuck[floor] = map[common.PiPin]bool{}
+		s.releaseAt[floor] = map[common.PiPin]time.Duration{}
+	}
+	return s
+}
+
+// ControllerRPi the RPi the controller drives the opener through
+func (s *Shaft) ControllerRPi() *ControllerRPi {
+	return s.controller
+}
+
+// FloorRPi the RPi of floor (numbered from 1)
+func (s *Shaft) FloorRPi(floor int) *FloorRPi {
+	return s.floors[floor-1]
+}
+
+// Run move the car every tick interval, until stop is closed
+func (s *Shaft) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.config.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Step(s.config.TickInterval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Step advance the simulation by d: move the car, release pressed buttons and send the
+// floors' watchers their signal changes
+func (s *Shaft) Step(d time.Duration) {
+	s.mu.Lock()
+	from := s.position
+	s.elapsed += d
+	if !s.stalled && s.motor != motorStopped {
+		travel := s.config.FloorsPerSec * s.speedFactor * d.Seconds()
+		if s.motor == motorDown {
+			travel = -travel
+		}
+		s.position = math.Max(1-s.config.OverTravel, math.Min(float64(s.config.NumFloors)+s.config.OverTravel, s.position+travel))
+		if s.position == from {
+			log.Warnf("simulated car is against the end of the shaft at %.1f", s.position)
+			s.motor = motorStopped
+		}
+	}
+	to := s.position
+	for floor := range s.releaseAt {
+		for pin, at := range s.releaseAt[floor] {
+			if s.elapsed >= at {
+				delete(s.releaseAt[floor], pin)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, floor := range s.floors {
+		floor.update(from, to)
+	}
+}
+
+// PressButton press a floor's button (a call button or StopRequested) for the press duration
+func (s *Shaft) PressButton(floor int, pin common.PiPin) error {
+	if err := s.checkButton(floor, pin); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.releaseAt[floor][pin] = s.elapsed + s.config.PressDuration
+	s.mu.Unlock()
+	s.floors[floor-1].update(s.Position(), s.Position())
+	return nil
+}
+
+// SetStalled stall (or free) the motor, a stalled motor runs but doesn't move the car
+func (s *Shaft) SetStalled(stalled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stalled = stalled
+}
+
+// SetSpeedFactor run the motor at a fraction of its normal speed
+func (s *Shaft) SetSpeedFactor(factor float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.speedFactor = factor
+}
+
+// SetMissingSensor make a floor's AtFloor sensor never turn on (or work again)
+func (s *Shaft) SetMissingSensor(floor int, missing bool) error {
+	if err := s.checkFloor(floor); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.missingSensors[floor] = missing
+	s.mu.Unlock()
+	s.floors[floor-1].update(s.Position(), s.Position())
+	return nil
+}
+
+// SetStuckButton make a floor's button stay on (or release it)
+func (s *Shaft) SetStuckButton(floor int, pin common.PiPin, stuck bool) error {
+	if err := s.checkButton(floor, pin); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.stuck[floor][pin] = stuck
+	s.mu.Unlock()
+	s.floors[floor-1].update(s.Position(), s.Position())
+	return nil
+}
+
+// Position the car's position in floors
+func (s *Shaft) Position() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position
+}
+
+// GetStatus get the simulated shaft's state
+func (s *Shaft) GetStatus() *Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := &Status{Position: s.position, Motor: s.motor.String(), Stalled: s.stalled, SpeedFactor: s.speedFactor}
+	for floor := 1; floor <= s.config.NumFloors; floor++ {
+		if s.missingSensors[floor] {
+			status.MissingSensors = append(status.MissingSensors, floor)
+		}
+		for pin, stuck := range s.stuck[floor] {
+			if stuck {
+				status.StuckButtons = append(status.StuckButtons, fmt.Sprintf("floor%d %s", floor, pin))
+			}
+		}
+	}
+	return status
+}
+
+func (s *Shaft) checkFloor(floor int) error {
+	if floor < 1 || floor > s.config.NumFloors {
+		return fmt.Errorf("no floor %d in a %d floor shaft", floor, s.config.NumFloors)
+	}
+	return nil
+}
+
+func (s *Shaft) checkButton(floor int, pin common.PiPin) error {
+	if err := s.checkFloor(floor); err != nil {
+		return err
+	}
+	if requested, ok := pin.RequestedFloor(); pin != common.StopRequested && (!ok || requested > s.config.NumFloors) {
+		return fmt.Errorf("%s is not a button", pin)
+	}
+	return nil
+}
+
+func (s *Shaft) setMotor(motor motorState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.motor != motor {
+		log.Infof("simulated motor %s", motor)
+	}
+	s.motor = motor
+}
+
+// limitOnLocked whether the limit switch pin is tripped with the car at position, the caller
+// must hold mu
+func (s *Shaft) limitOnLocked(pin common.PiPin, position float64) bool {
+	if s.config.OverTravel <= 0 {
+		return false
+	}
+	switch pin {
+	case common.TopLimit:
+		return position >= float64(s.config.NumFloors)+s.config.OverTravel/2
+	case common.BottomLimit:
+		return position <= 1-s.config.OverTravel/2
+	}
+	return false
+}
+
+// sensorOn whether floor's AtFloor sensor sees the car at position, the caller must hold mu
+func (s *Shaft) sensorOnLocked(floor int, position float64) bool {
+	return !s.missingSensors[floor] && math.Abs(position-float64(floor)) <= s.config.SensorWidth/2
+}
+
+// passedSensorLocked whether the car went all the way through floor's sensor window moving
+// from one position to another within a step, the caller must hold mu
+func (s *Shaft) passedSensorLocked(floor int, from float64, to float64) bool {
+	low, high := math.Min(from, to), math.Max(from, to)
+	return !s.missingSensors[floor] && low < float64(floor)-s.config.SensorWidth/2 && high > float64(floor)+s.config.SensorWidth/2
+}
+
+// buttonOnLocked whether floor's button is on, the caller must hold mu
+func (s *Shaft) buttonOnLocked(floor int, pin common.PiPin) bool {
+	_, pressed := s.releaseAt[floor][pin]
+	return pressed || s.stuck[floor][pin]
+}
+
+// ControllerRPi the controller's simulated RPi, signals on the opener's relays run its motor
+type ControllerRPi struct {
+	shaft *Shaft
+}
+
+// SendSignal press one of the opener's relays
+func (c *ControllerRPi) SendSignal(pin common.PiPin) error {
+	switch pin {
+	case common.OpenerUp:
+		c.shaft.setMotor(motorUp)
+	case common.OpenerDown:
+		c.shaft.setMotor(motorDown)
+	case common.OpenerStop:
+		c.shaft.setMotor(motorStopped)
+	default:
+		return fmt.Errorf("the simulated opener has no %s relay", pin)
+	}
+	return nil
+}
+
+// SetSignal turning a relay on presses it, turning it off does nothing
+func (c *ControllerRPi) SetSignal(pin common.PiPin, value bool) error {
+	if !value {
+		return nil
+	}
+	return c.SendSignal(pin)
+}
+
+// GetSignal the limit switches, the opener's relays are only ever momentarily on
+func (c *ControllerRPi) GetSignal(pin common.PiPin) (bool, error) {
+	c.shaft.mu.Lock()
+	defer c.shaft.mu.Unlock()
+	return c.shaft.limitOnLocked(pin, c.shaft.position), nil
+}
+
+// WatchSignal the controller's limit switches can only be polled
+func (c *ControllerRPi) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	return nil, common.ErrWatchNotSupported
+}
+
+// FloorRPi a floor's simulated RPi, it reads the floor's AtFloor sensor and buttons
+type FloorRPi struct {
+	shaft    *Shaft
+	floor    int
+	watchers map[common.PiPin][]chan common.SignalEvent
+	last     map[common.PiPin]bool // the signal values the watchers were last sent
+	mu       sync.Mutex
+}
+
+// SendSignal a floor has no outputs
+func (f *FloorRPi) SendSignal(pin common.PiPin) error {
+	return fmt.Errorf("floor%d has no %s output", f.floor, pin)
+}
+
+// GetSignal read the floor's sensor or button
+func (f *FloorRPi) GetSignal(pin common.PiPin) (bool, error) {
+	f.shaft.mu.Lock()
+	defer f.shaft.mu.Unlock()
+	return f.signalLocked(pin, f.shaft.position), nil
+}
+
+// WatchSignal get the floor's sensor or button changes as the simulation steps
+func (f *FloorRPi) WatchSignal(pin common.PiPin) (<-chan common.SignalEvent, error) {
+	value, _ := f.GetSignal(pin)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.last[pin]; !ok {
+		f.last[pin] = value
+	}
+	watcher := make(chan common.SignalEvent, 64)
+	f.watchers[pin] = append(f.watchers[pin], watcher)
+	return watcher, nil
+}
+
+// signalLocked the pin's value with the car at position, the caller must hold the shaft's mu
+func (f *FloorRPi) signalLocked(pin common.PiPin, position float64) bool {
+	if pin == common.AtFloor {
+		return f.shaft.sensorOnLocked(f.floor, position)
+	}
+	return f.shaft.buttonOnLocked(f.floor, pin)
+}
+
+// update send the watchers the changes from the car moving from one position to another
+func (f *FloorRPi) update(from float64, to float64) {
+	pins := f.watchedPins()
+	f.shaft.mu.Lock()
+	passed := f.shaft.passedSensorLocked(f.floor, from, to)
+	values := map[common.PiPin]bool{}
+	for pin := range pins {
+		values[pin] = f.signalLocked(pin, to)
+	}
+	f.shaft.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if _, watched := values[common.AtFloor]; watched && passed && !f.last[common.AtFloor] && !values[common.AtFloor] {
+		// the car went through the sensor between steps, it still saw the car go by
+		f.emitLocked(common.AtFloor, common.Rising, now)
+		f.emitLocked(common.AtFloor, common.Falling, now)
+	}
+	for pin, value := range values {
+		if value == f.last[pin] {
+			continue
+		}
+		edge := common.Falling
+		if value {
+			edge = common.Rising
+		}
+		f.emitLocked(pin, edge, now)
+		f.last[pin] = value
+	}
+}
+
+// watchedPins the pins that have watchers
+func (f *FloorRPi) watchedPins() map[common.PiPin]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pins := map[common.PiPin]bool{}
+	for pin := range f.watchers {
+		pins[pin] = true
+	}
+	return pins
+}
+
+// emitLocked send an event to the pin's watchers, the caller must hold mu
+func (f *FloorRPi) emitLocked(pin common.PiPin, edge common.Edge, at time.Time) {
+	event := common.SignalEvent{Pin: pin, Edge: edge, Time: at}
+	for _, watcher := range f.watchers[pin] {
+		select {
+		case watcher <- event:
+		default:
+			log.Warnf("floor%d %s watcher is not keeping up, dropped %s event", f.floor, pin, edge)
+		}
+	}
+}
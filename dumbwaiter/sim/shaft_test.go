@@ -0,0 +1,163 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/JeanetteBruno/jbruno/dumbwaiter/common"
+)
+
+// testConfig a car that moves a floor per second
+var testConfig = Config{
+	NumFloors:     3,
+	FloorsPerSec:  1,
+	SensorWidth:   0.1,
+	StartFloor:    1,
+	TickInterval:  10 * time.Millisecond,
+	PressDuration: 200 * time.Millisecond,
+}
+
+// nextEdge the watcher's next event's edge, or a failure when there isn't one
+func nextEdge(t *testing.T, events <-chan common.SignalEvent) common.Edge {
+	select {
+	case event := <-events:
+		return event.Edge
+	default:
+		t.Fatal("expected an event")
+		return 0
+	}
+}
+
+func assertNoEvent(t *testing.T, events <-chan common.SignalEvent) {
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s %s event", event.Pin, event.Edge)
+	default:
+	}
+}
+
+// TestCarPassesFloorSensor drive the car up past floor 2 to floor 3, floor 2 sees it come and
+// go, floor 3 sees it arrive
+func TestCarPassesFloorSensor(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	floor1, _ := shaft.FloorRPi(1).WatchSignal(common.AtFloor)
+	floor2, _ := shaft.FloorRPi(2).WatchSignal(common.AtFloor)
+	floor3, _ := shaft.FloorRPi(3).WatchSignal(common.AtFloor)
+	atFloor, _ := shaft.FloorRPi(1).GetSignal(common.AtFloor)
+	assert.True(t, atFloor, "the car starts at floor 1")
+
+	assert.NoError(t, shaft.ControllerRPi().SendSignal(common.OpenerUp))
+	shaft.Step(100 * time.Millisecond)
+	assert.Equal(t, common.Falling, nextEdge(t, floor1))
+	shaft.Step(800 * time.Millisecond)
+	assertNoEvent(t, floor2)
+	shaft.Step(100 * time.Millisecond)
+	assert.Equal(t, common.Rising, nextEdge(t, floor2))
+	atFloor, _ = shaft.FloorRPi(2).GetSignal(common.AtFloor)
+	assert.True(t, atFloor)
+	shaft.Step(1 * time.Second)
+	assert.Equal(t, common.Falling, nextEdge(t, floor2))
+	assert.Equal(t, common.Rising, nextEdge(t, floor3))
+
+	// the car stops against the top of the shaft
+	shaft.Step(1 * time.Second)
+	assert.Equal(t, 3.0, shaft.Position())
+	assert.Equal(t, "stopped", shaft.GetStatus().Motor)
+}
+
+// TestFastCarStillSeen a step that carries the car right through a sensor still reports it
+func TestFastCarStillSeen(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	floor2, _ := shaft.FloorRPi(2).WatchSignal(common.AtFloor)
+	shaft.ControllerRPi().SendSignal(common.OpenerUp)
+	shaft.Step(1500 * time.Millisecond)
+	assert.Equal(t, common.Rising, nextEdge(t, floor2))
+	assert.Equal(t, common.Falling, nextEdge(t, floor2))
+}
+
+func TestStop(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	shaft.ControllerRPi().SendSignal(common.OpenerUp)
+	shaft.Step(500 * time.Millisecond)
+	shaft.ControllerRPi().SendSignal(common.OpenerStop)
+	shaft.Step(500 * time.Millisecond)
+	assert.InDelta(t, 1.5, shaft.Position(), 1e-9)
+}
+
+func TestStalledAndSlowMotor(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	shaft.SetStalled(true)
+	shaft.ControllerRPi().SendSignal(common.OpenerUp)
+	shaft.Step(500 * time.Millisecond)
+	assert.Equal(t, 1.0, shaft.Position(), "a stalled motor doesn't move the car")
+
+	shaft.SetStalled(false)
+	shaft.SetSpeedFactor(0.5)
+	shaft.Step(500 * time.Millisecond)
+	assert.InDelta(t, 1.25, shaft.Position(), 1e-9)
+}
+
+// TestLimitSwitches the controller's limit switches trip when the car goes past the end floors,
+// it stops against the end of the shaft a little further on
+func TestLimitSwitches(t *testing.T) {
+	config := testConfig
+	config.OverTravel = 0.2
+	shaft := NewShaft(config)
+	shaft.ControllerRPi().SendSignal(common.OpenerUp)
+	shaft.Step(2 * time.Second)
+	tripped, _ := shaft.ControllerRPi().GetSignal(common.TopLimit)
+	assert.False(t, tripped, "the top floor is inside the limits")
+	shaft.Step(100 * time.Millisecond)
+	tripped, _ = shaft.ControllerRPi().GetSignal(common.TopLimit)
+	assert.True(t, tripped)
+	shaft.Step(1 * time.Second)
+	shaft.Step(100 * time.Millisecond)
+	assert.InDelta(t, 3.2, shaft.Position(), 1e-9)
+	assert.Equal(t, "stopped", shaft.GetStatus().Motor)
+
+	shaft.ControllerRPi().SendSignal(common.OpenerDown)
+	shaft.Step(3 * time.Second)
+	tripped, _ = shaft.ControllerRPi().GetSignal(common.TopLimit)
+	assert.False(t, tripped)
+	tripped, _ = shaft.ControllerRPi().GetSignal(common.BottomLimit)
+	assert.True(t, tripped)
+}
+
+func TestMissingSensor(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	assert.NoError(t, shaft.SetMissingSensor(2, true))
+	floor2, _ := shaft.FloorRPi(2).WatchSignal(common.AtFloor)
+	shaft.ControllerRPi().SendSignal(common.OpenerUp)
+	shaft.Step(1 * time.Second)
+	atFloor, _ := shaft.FloorRPi(2).GetSignal(common.AtFloor)
+	assert.False(t, atFloor)
+	shaft.Step(1 * time.Second)
+	assertNoEvent(t, floor2)
+	assert.Error(t, shaft.SetMissingSensor(4, true))
+}
+
+func TestButtons(t *testing.T) {
+	shaft := NewShaft(testConfig)
+	button, _ := shaft.FloorRPi(2).WatchSignal(common.Floor3Requested)
+
+	assert.NoError(t, shaft.PressButton(2, common.Floor3Requested))
+	assert.Equal(t, common.Rising, nextEdge(t, button))
+	pressed, _ := shaft.FloorRPi(2).GetSignal(common.Floor3Requested)
+	assert.True(t, pressed)
+	shaft.Step(100 * time.Millisecond)
+	assertNoEvent(t, button)
+	shaft.Step(100 * time.Millisecond)
+	assert.Equal(t, common.Falling, nextEdge(t, button), "the press is released after the press duration")
+
+	assert.NoError(t, shaft.SetStuckButton(2, common.Floor3Requested, true))
+	assert.Equal(t, common.Rising, nextEdge(t, button))
+	shaft.Step(1 * time.Second)
+	pressed, _ = shaft.FloorRPi(2).GetSignal(common.Floor3Requested)
+	assert.True(t, pressed, "a stuck button stays on")
+	assert.Equal(t, []string{"floor2 Floor3Requested"}, shaft.GetStatus().StuckButtons)
+
+	assert.Error(t, shaft.PressButton(2, common.AtFloor), "AtFloor is not a button")
+	assert.Error(t, shaft.PressButton(0, common.Floor1Requested))
+}